@@ -6,6 +6,8 @@ require (
 	github.com/go-git/go-git/v5 v5.2.0
 	github.com/jfrog/jfrog-client-go v0.19.1
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_golang v1.9.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/stretchr/testify v1.6.1
 	gopkg.in/yaml.v2 v2.4.0
 )
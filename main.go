@@ -1,16 +1,21 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
-	"strconv"
 
-	"github.com/go-git/go-git/v5"
 	"github.com/jfrog/jfrog-client-go/artifactory"
 	"github.com/jfrog/jfrog-client-go/utils/log"
 	"github.com/jfrog/jfrog-vcs-agent/utils"
 )
 
+// When set, the agent only prints the builds it would publish.
+var dryRun = flag.Bool("dry-run", false, "Clone the project and print the commits that would be built, published and scanned, without running them.")
+
+// The functions which release the resources of the run, such as the cloned project.
+var cleanups []func()
+
 func init() {
 	log.SetLogger(log.NewLogger(log.INFO, nil))
 }
@@ -19,14 +24,64 @@ func init() {
 // 1. Load config.
 // 2. Clone & build the git repository.
 // 3. Publish & scan the build.
+// In dry-run mode, step 3 is replaced by printing the plan of builds to publish.
 func main() {
+	flag.Parse()
+	defer runCleanups()
+	addCleanup(utils.CleanupNetwork)
 	buildConfig, ArtifactoryServicesManager, err := utils.LoadBuildConfig()
 	assertNoError(err)
-	gitRepo, projectPath, cleanup, err := setupAgent(buildConfig, ArtifactoryServicesManager)
+	utils.SetLogContext(buildConfig.ProjectName, "", "")
+	buildNumberScheme, err := utils.NewBuildNumberScheme(buildConfig.Jfrog)
+	assertNoError(err)
+	notifiers, err := utils.NewNotifiers(buildConfig.Notifications)
+	assertNoError(err)
+	// The rate limited notifications are sent when the agent exits, including when the run fails.
+	addCleanup(notifiers.SendSkipped)
+	statusReporter, err := utils.NewCommitStatusReporter(buildConfig.Vcs)
 	assertNoError(err)
-	defer cleanup()
-	for _, name := range buildConfig.Vcs.Branches {
-		assertNoError(scanBranch(name, projectPath, buildConfig, gitRepo, ArtifactoryServicesManager))
+	if !*dryRun {
+		metricsListener, err := utils.ServeMetrics(buildConfig.Metrics)
+		assertNoError(err)
+		if metricsListener != nil {
+			addCleanup(func() { metricsListener.Close() })
+		}
+	}
+	if !*dryRun {
+		// The metrics are pushed when the agent exits, including when the run fails.
+		addCleanup(func() {
+			if err := utils.PushMetrics(buildConfig.Metrics, buildConfig.ProjectName); err != nil {
+				log.Error("Failed to push the metrics: " + err.Error())
+			}
+		})
+	}
+	gitBackend, projectPath, cleanup, err := setupAgent(buildConfig, ArtifactoryServicesManager, *dryRun)
+	assertNoError(err)
+	addCleanup(cleanup)
+	gitRepo, err := gitBackend.Repository()
+	assertNoError(err)
+	branches, err := utils.GetBranchesToScan(gitRepo, buildConfig.Vcs)
+	assertNoError(err)
+	var plan utils.Plan
+	for _, name := range branches {
+		branchPlan, err := scanBranch(name, projectPath, buildConfig, buildNumberScheme, gitBackend, ArtifactoryServicesManager, notifiers, statusReporter, *dryRun)
+		assertNoError(err)
+		plan = append(plan, branchPlan...)
+	}
+	if buildConfig.Vcs.Tags != nil {
+		tags, err := utils.GetTagsToScan(gitRepo, buildConfig.Vcs.Tags)
+		assertNoError(err)
+		for _, tag := range tags {
+			tagPlan, err := scanTag(tag, projectPath, buildConfig, buildNumberScheme, gitBackend, ArtifactoryServicesManager, notifiers, statusReporter, *dryRun)
+			assertNoError(err)
+			plan = append(plan, tagPlan...)
+		}
+	}
+	utils.SetLogContext(buildConfig.ProjectName, "", "")
+	if *dryRun {
+		log.Output(plan.String())
+		log.Info("Dry run completed, nothing was built or published")
+		return
 	}
 	log.Info(fmt.Sprintf("Git repository scan completed"))
 }
@@ -35,30 +90,36 @@ func main() {
 // 1. Clone the project.
 // 2. Pre-configured the project with the Artifactory server and repositories.
 // 3. Set build envarament varbles
-// Returns (git repository details, local path to project, cleanup func, error).
-func setupAgent(buildConfig *utils.BuildConfig, ArtifactoryServicesManager artifactory.ArtifactoryServicesManager) (*git.Repository, string, func(), error) {
+// A dry run doesn't build or publish, so only the project is cloned.
+// Returns (git backend of the project, local path to project, cleanup func, error).
+func setupAgent(buildConfig *utils.BuildConfig, ArtifactoryServicesManager artifactory.ArtifactoryServicesManager, dryRun bool) (utils.GitBackend, string, func(), error) {
 	// Create artifactory server on agent.
-	if err := utils.CreateArtServer(buildConfig); err != nil {
-		return nil, "", nil, err
+	if !dryRun {
+		if err := utils.CreateArtServer(buildConfig); err != nil {
+			return nil, "", nil, err
+		}
 	}
-	cloneDir, err := utils.CreateCloneDir()
+	gitBackend, cloneDir, err := setupProject(buildConfig.Vcs)
 	if err != nil {
 		return nil, "", nil, err
 	}
-	log.Info("Cloning project '" + buildConfig.Vcs.Url + "' to '" + cloneDir + "'")
-	gitRepo, err := utils.Clone(cloneDir, buildConfig.Vcs)
-	if err != nil {
-		return nil, "", nil, err
+	removeCloneDir := func() {
+		if !buildConfig.Vcs.PersistentClone {
+			if err := os.RemoveAll(cloneDir); err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+	if dryRun {
+		return gitBackend, cloneDir, removeCloneDir, nil
 	}
 	log.Info("Configure the Artifactory server and repositories for each technology")
 	if err := utils.CreateBuildToolConfigs(cloneDir, buildConfig); err != nil {
 		return nil, "", nil, err
 	}
 	log.Info("The agent is fully setup.")
-	return gitRepo, cloneDir, func() {
-		if err := os.RemoveAll(cloneDir); err != nil {
-			log.Error(err.Error())
-		}
+	return gitBackend, cloneDir, func() {
+		removeCloneDir()
 		if err := utils.UnsetJfrogBuildProps(); err != nil {
 			log.Error(err.Error())
 		}
@@ -68,44 +129,274 @@ func setupAgent(buildConfig *utils.BuildConfig, ArtifactoryServicesManager artif
 	}, nil
 }
 
-func scanBranch(branch, projectPath string, buildConfig *utils.BuildConfig, gitRepo *git.Repository, ArtifactoryServicesManager artifactory.ArtifactoryServicesManager) error {
-	if err := utils.CheckoutBranch(branch, gitRepo); err != nil {
-		return err
+// Clone the project, or update the existing clone if 'PersistentClone' is set.
+// Returns (git backend of the project, local path to project, error).
+func setupProject(vcs *utils.Vcs) (utils.GitBackend, string, error) {
+	if vcs.PersistentClone {
+		cloneDir, err := utils.GetCloneDir()
+		if err != nil {
+			return nil, "", err
+		}
+		gitBackend, err := utils.NewGitBackend(cloneDir, vcs)
+		if err != nil {
+			return nil, "", err
+		}
+		return gitBackend, cloneDir, utils.OpenOrClone(cloneDir, vcs, gitBackend)
 	}
-	buildName := utils.GetBranchBuildName(branch, buildConfig)
-	bi, err := utils.GetLatestBuildInfo(ArtifactoryServicesManager, buildName)
+	cloneDir, err := utils.CreateCloneDir()
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-	commits, err := utils.GetCommitsToScan(bi, gitRepo, buildConfig.Vcs.Url)
+	gitBackend, err := utils.NewGitBackend(cloneDir, vcs)
 	if err != nil {
-		return err
+		return nil, "", err
+	}
+	log.Info("Cloning project '" + vcs.Url + "' to '" + cloneDir + "'")
+	return gitBackend, cloneDir, utils.Clone(gitBackend, vcs)
+}
+
+// Build, publish and scan the new commits of a branch.
+// Returns the plan of the builds for the branch. If 'dryRun' is true, the plan is returned without running anything.
+func scanBranch(branch, projectPath string, buildConfig *utils.BuildConfig, buildNumberScheme utils.BuildNumberScheme, gitBackend utils.GitBackend, ArtifactoryServicesManager artifactory.ArtifactoryServicesManager, notifiers *utils.Notifiers, statusReporter *utils.CommitStatusReporter, dryRun bool) (utils.Plan, error) {
+	utils.SetLogContext(buildConfig.ProjectName, branch, "")
+	metrics := utils.NewBranchMetrics(buildConfig.ProjectName, branch)
+	if err := utils.CheckoutBranch(branch, gitBackend); err != nil {
+		return nil, err
 	}
+	buildName, err := utils.GetBranchBuildName(branch, buildConfig)
+	if err != nil {
+		return nil, err
+	}
+	prevBuildNumber, commits, err := utils.GetBranchCommitsToScan(ArtifactoryServicesManager, buildName, gitBackend, buildConfig)
+	if err != nil {
+		return nil, err
+	}
+	var plan utils.Plan
 	for i, commit := range commits {
-		if err := utils.CheckoutHash(commit.Hash.String(), gitRepo); err != nil {
-			return err
+		buildNumber, err := buildNumberScheme.GetBuildNumber(&utils.BuildNumberParams{
+			PrevBuildNumber: prevBuildNumber,
+			RunNumber:       i,
+			CommitSha:       utils.ToShortCommitHash(commit.Hash.String()),
+		})
+		if err != nil {
+			return nil, err
 		}
-		utils.SetBuildProps(buildName, utils.ToShortCommitHash(commit.Hash.String()), bi.Number, strconv.Itoa(i))
-		if err := utils.Build(buildConfig.BuildCommand, projectPath); err != nil {
-			log.Info("Failed to build commit '" + commit.Hash.String() + "' skipping to the next commit...")
-			continue
+		plan = append(plan, utils.PlanEntry{Branch: branch, Commit: commit.Hash.String(), BuildName: buildName, BuildNumber: buildNumber})
+	}
+	if dryRun {
+		return plan, nil
+	}
+	retention := utils.GetRetention(branch, buildConfig.Jfrog)
+	timeline, err := utils.LoadVulnerabilityTimeline(ArtifactoryServicesManager, buildName, branch, buildConfig.Jfrog)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range plan {
+		metrics.SetQueueDepth(len(plan) - i)
+		if err := scanCommit(entry.Commit, branch, entry.BuildName, entry.BuildNumber, projectPath, buildConfig, gitBackend, ArtifactoryServicesManager, retention, timeline, metrics, notifiers, statusReporter); err != nil {
+			return nil, err
 		}
-		if err := utils.Bag(projectPath); err != nil {
-			return err
+	}
+	metrics.BranchScanned()
+	return plan, nil
+}
+
+// Build, publish and scan a release tag, unless it was already scanned.
+// Returns the plan of the tag build. If 'dryRun' is true, the plan is returned without running anything.
+func scanTag(tag, projectPath string, buildConfig *utils.BuildConfig, buildNumberScheme utils.BuildNumberScheme, gitBackend utils.GitBackend, ArtifactoryServicesManager artifactory.ArtifactoryServicesManager, notifiers *utils.Notifiers, statusReporter *utils.CommitStatusReporter, dryRun bool) (utils.Plan, error) {
+	buildName, err := utils.GetTagBuildName(tag, buildConfig)
+	if err != nil {
+		return nil, err
+	}
+	scanned, err := utils.IsBuildExists(ArtifactoryServicesManager, buildName, buildConfig.Jfrog)
+	if err != nil {
+		return nil, err
+	}
+	if scanned {
+		log.Info("Tag '" + tag + "' was already scanned. Skipping...")
+		return nil, nil
+	}
+	gitRepo, err := gitBackend.Repository()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := utils.GetTagCommit(tag, gitRepo)
+	if err != nil {
+		return nil, err
+	}
+	buildNumber, err := buildNumberScheme.GetBuildNumber(&utils.BuildNumberParams{
+		CommitSha: utils.ToShortCommitHash(commit.Hash.String()),
+		Tag:       tag,
+	})
+	if err != nil {
+		return nil, err
+	}
+	plan := utils.Plan{{Tag: tag, Commit: commit.Hash.String(), BuildName: buildName, BuildNumber: buildNumber}}
+	if dryRun {
+		return plan, nil
+	}
+	metrics := utils.NewBranchMetrics(buildConfig.ProjectName, "tags/"+tag)
+	if err := scanCommit(commit.Hash.String(), "tags/"+tag, buildName, buildNumber, projectPath, buildConfig, gitBackend, ArtifactoryServicesManager, nil, nil, metrics, notifiers, statusReporter); err != nil {
+		return nil, err
+	}
+	metrics.BranchScanned()
+	return plan, nil
+}
+
+// Checkout, build, publish and scan a single commit.
+// A commit which fails to build is skipped. With 'Clean', the files left by the previous build are removed before the build.
+// The published build stores the commit as the scan cursor. The retention policy, if not nil, is applied after publishing. A build with violations is marked, to be kept by retention policies.
+// The scan result is recorded in the vulnerability timeline of the branch, if not nil, and compared to the previous scanned commit.
+// With 'FailOnNewViolationsOnly', violations which were found in the previous scanned commit don't fail the scan.
+// The stages and failures are recorded in the branch metrics. The output of the commands is archived, if 'BuildLogsRepo' is set.
+// Violations found by Xray are sent to the notifiers, and the result is reported to the VCS as a commit status.
+// A tag is scanned under the branch 'tags/<tag>'.
+func scanCommit(hash, branch, buildName, buildNumber, projectPath string, buildConfig *utils.BuildConfig, gitBackend utils.GitBackend, ArtifactoryServicesManager artifactory.ArtifactoryServicesManager, retention *utils.Retention, timeline *utils.VulnerabilityTimeline, metrics *utils.BranchMetrics, notifiers *utils.Notifiers, statusReporter *utils.CommitStatusReporter) error {
+	utils.SetLogContext(buildConfig.ProjectName, branch, hash)
+	commitLog, err := utils.StartCommitLog(buildName, buildNumber, hash, buildConfig.Jfrog)
+	if err != nil {
+		return err
+	}
+	statusReporter.Report(hash, &utils.CommitStatusUpdate{State: utils.CommitStatePending, Description: "Building and scanning the commit"})
+	status, published, violations := utils.CommitFailed, false, false
+	defer func() {
+		if err := commitLog.Archive(ArtifactoryServicesManager, status, published, buildConfig.Jfrog); err != nil {
+			log.Error("Failed to archive the log of commit '" + hash + "': " + err.Error())
 		}
-		if err := utils.Publish(); err != nil {
+		statusReporter.Report(hash, getCommitStatusUpdate(status, violations, published, buildName, buildNumber, buildConfig.Jfrog))
+	}()
+	if err := utils.CheckoutHash(hash, gitBackend); err != nil {
+		metrics.BuildFailed(utils.CheckoutFailure)
+		return err
+	}
+	// The worktree is verified before the submodules and the LFS files update it.
+	if err := utils.CleanWorktree(gitBackend, buildConfig.Vcs); err != nil {
+		metrics.BuildFailed(utils.CleanFailure)
+		return err
+	}
+	gitRepo, err := gitBackend.Repository()
+	if err != nil {
+		return err
+	}
+	if err := utils.UpdateSubmodules(gitRepo, buildConfig.Vcs); err != nil {
+		metrics.BuildFailed(utils.SubmodulesFailure)
+		return err
+	}
+	if buildConfig.Vcs.Lfs {
+		if err := utils.SmudgeLfsFiles(projectPath, gitRepo, buildConfig.Vcs); err != nil {
+			metrics.BuildFailed(utils.LfsFailure)
 			return err
 		}
-		if err := utils.BuildScan(); err != nil {
+	}
+	if err := utils.SetBuildProps(buildName, buildNumber, buildConfig.Jfrog.ProjectKey); err != nil {
+		return err
+	}
+	if err := metrics.TimeStage(utils.BuildStage, func() error { return utils.Build(buildConfig.BuildCommand, projectPath) }); err != nil {
+		metrics.BuildFailed(utils.BuildFailure)
+		status = utils.CommitBuildFailed
+		log.Info("Failed to build commit '" + hash + "' skipping to the next commit...")
+		return nil
+	}
+	if err := utils.Bag(projectPath); err != nil {
+		metrics.BuildFailed(utils.BagFailure)
+		return err
+	}
+	err = metrics.TimeStage(utils.PublishStage, func() error {
+		if err := utils.Publish(buildConfig.Jfrog.ProjectKey); err != nil {
 			return err
 		}
+		published = true
+		return utils.SetScanCursor(ArtifactoryServicesManager, buildName, buildNumber, hash, buildConfig.Jfrog)
+	})
+	if err != nil {
+		metrics.PublishFailed()
+		return err
+	}
+	if err := utils.ApplyRetention(ArtifactoryServicesManager, buildName, retention, buildConfig.Jfrog); err != nil {
+		return err
+	}
+	var scanResult *utils.ScanResult
+	err = metrics.TimeStage(utils.ScanStage, func() (err error) {
+		scanResult, err = utils.BuildScan(buildConfig.Jfrog.ProjectKey)
+		return
+	})
+	if err != nil && !utils.IsBuildViolationsError(err) {
+		metrics.ScanFailed()
+		return err
+	}
+	diff, recordErr := timeline.Record(ArtifactoryServicesManager, gitBackend, hash, buildNumber, scanResult)
+	if recordErr != nil {
+		log.Error("Failed to record commit '" + hash + "' in the vulnerability timeline: " + recordErr.Error())
+	}
+	if utils.IsBuildViolationsError(err) {
+		violations = true
+		if markErr := utils.MarkBuildViolations(ArtifactoryServicesManager, buildName, buildNumber, buildConfig.Jfrog); markErr != nil {
+			log.Error(markErr.Error())
+		}
+		author, authorErr := utils.GetCommitAuthor(hash, gitRepo)
+		if authorErr != nil {
+			log.Warn(authorErr.Error())
+		}
+		notifiers.NotifyViolations(&utils.ViolationsEvent{
+			Project:     buildConfig.ProjectName,
+			Branch:      branch,
+			BuildName:   buildName,
+			BuildNumber: buildNumber,
+			Commit:      hash,
+			Author:      author,
+			Result:      scanResult,
+			Diff:        diff,
+		})
+		if buildConfig.Jfrog.FailOnNewViolationsOnly && diff != nil && len(diff.Filter(utils.NewFinding)) == 0 {
+			log.Info("Xray found no new violations since the previous scanned commit")
+			violations, err = false, nil
+		}
+	}
+	metrics.CommitScanned()
+	status = utils.CommitScanned
+	return err
+}
+
+// Returns the commit status of a commit scan. A published build is linked from the status.
+func getCommitStatusUpdate(status string, violations, published bool, buildName, buildNumber string, jfrog *utils.JfrogDetails) *utils.CommitStatusUpdate {
+	newOnly := jfrog.FailOnNewViolationsOnly && jfrog.ScanResultsRepo != ""
+	update := &utils.CommitStatusUpdate{State: utils.CommitStateSuccess, Description: "No violations were found by Xray"}
+	if newOnly {
+		update.Description = "No new violations were found by Xray"
+	}
+	switch {
+	case status == utils.CommitBuildFailed:
+		update = &utils.CommitStatusUpdate{State: utils.CommitStateError, Description: "The build failed, the commit wasn't scanned"}
+	case status != utils.CommitScanned:
+		update = &utils.CommitStatusUpdate{State: utils.CommitStateError, Description: "The commit failed to publish or scan"}
+	case violations && newOnly:
+		update = &utils.CommitStatusUpdate{State: utils.CommitStateFailure, Description: "New violations were found by Xray"}
+	case violations:
+		update = &utils.CommitStatusUpdate{State: utils.CommitStateFailure, Description: "Violations were found by Xray"}
+	}
+	if published {
+		update.TargetUrl = utils.GetBuildUrl(buildName, buildNumber, jfrog)
+	}
+	return update
+}
+
+// Register a function which releases a resource of the run. The functions run in reverse order when the agent exits.
+func addCleanup(cleanup func()) {
+	cleanups = append(cleanups, cleanup)
+}
+
+func runCleanups() {
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
 	}
-	return nil
+	cleanups = nil
 }
 
 func assertNoError(err error) {
 	if err != nil {
 		log.Error(err.Error())
+		// os.Exit doesn't run the deferred functions.
+		runCleanups()
 		os.Exit(1)
 	}
 }
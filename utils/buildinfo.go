@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jfrog/jfrog-client-go/artifactory"
+	"github.com/jfrog/jfrog-client-go/artifactory/buildinfo"
+	"github.com/jfrog/jfrog-client-go/artifactory/services"
+	servicesutils "github.com/jfrog/jfrog-client-go/artifactory/services/utils"
+)
+
+const (
+	// The default repository of the published build-infos.
+	defaultBuildInfoRepo = "artifactory-build-info"
+	// The time format of the build API.
+	buildStartedFormat = "2006-01-02T15:04:05.000-0700"
+)
+
+// The response of the 'All Build Numbers' REST API.
+type buildNumbersResponse struct {
+	BuildsNumbers []buildNumberItem `json:"buildsNumbers"`
+}
+
+type buildNumberItem struct {
+	// The build number, prefixed with '/'.
+	Uri     string `json:"uri"`
+	Started string `json:"started"`
+}
+
+// Returns the repository of the published build-infos.
+// Defaults to '<projectKey>-build-info' for a JFrog Project, and to 'artifactory-build-info' otherwise.
+func getBuildInfoRepo(jfrog *JfrogDetails) string {
+	switch {
+	case jfrog.BuildInfoRepo != "":
+		return jfrog.BuildInfoRepo
+	case jfrog.ProjectKey != "":
+		return jfrog.ProjectKey + "-build-info"
+	}
+	return defaultBuildInfoRepo
+}
+
+// Returns the latest build-info published under buildName, or nil if there is none.
+// Artifactory versions which don't support custom build-info repositories in the build API return no builds for them.
+// In that case, the latest build-info file is read from the repository.
+func findLatestBuildInfo(servicesManager artifactory.ArtifactoryServicesManager, buildName string, jfrog *JfrogDetails) (*buildinfo.BuildInfo, error) {
+	builds, err := getBuilds(servicesManager, buildName, jfrog)
+	if err != nil {
+		return nil, err
+	}
+	if len(builds) == 0 {
+		repo := getBuildInfoRepo(jfrog)
+		if repo == defaultBuildInfoRepo {
+			return nil, nil
+		}
+		return readLatestBuildInfoFile(servicesManager, buildName, repo)
+	}
+	return getBuildInfo(servicesManager, buildName, builds[0].Number, jfrog)
+}
+
+// A build published to Artifactory.
+type publishedBuild struct {
+	Number  string
+	Started time.Time
+}
+
+// Returns the builds of buildName, from the latest to the oldest started.
+func getBuilds(servicesManager artifactory.ArtifactoryServicesManager, buildName string, jfrog *JfrogDetails) ([]publishedBuild, error) {
+	response := new(buildNumbersResponse)
+	found, err := getFromBuildApi(servicesManager, "api/build/"+url.PathEscape(buildName), jfrog, response)
+	if err != nil || !found {
+		return nil, err
+	}
+	var builds []publishedBuild
+	for _, item := range response.BuildsNumbers {
+		build := publishedBuild{Number: strings.TrimPrefix(item.Uri, "/")}
+		if build.Started, err = time.Parse(buildStartedFormat, item.Started); err != nil {
+			return nil, fmt.Errorf("unexpected start time of build '%s/%s': %s", buildName, build.Number, err.Error())
+		}
+		builds = append(builds, build)
+	}
+	sort.SliceStable(builds, func(i, j int) bool {
+		return builds[i].Started.After(builds[j].Started)
+	})
+	return builds, nil
+}
+
+// Returns the build-info of a build number, or nil if it doesn't exist.
+func getBuildInfo(servicesManager artifactory.ArtifactoryServicesManager, buildName, buildNumber string, jfrog *JfrogDetails) (*buildinfo.BuildInfo, error) {
+	published := new(buildinfo.PublishedBuildInfo)
+	found, err := getFromBuildApi(servicesManager, "api/build/"+url.PathEscape(buildName)+"/"+url.PathEscape(buildNumber), jfrog, published)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &published.BuildInfo, nil
+}
+
+// Send a GET request to the build API, and decode the JSON response into result.
+// The build name and number in restApi must be escaped with url.PathEscape, since they may contain '/'.
+// Returns false if the build doesn't exist.
+func getFromBuildApi(servicesManager artifactory.ArtifactoryServicesManager, restApi string, jfrog *JfrogDetails, result interface{}) (bool, error) {
+	return getArtifactoryJson(servicesManager, restApi, getBuildApiParams(jfrog), result)
+}
+
+// Returns the query parameters of the build API, which select the JFrog Project and the build-info repository.
+func getBuildApiParams(jfrog *JfrogDetails) map[string]string {
+	params := make(map[string]string)
+	if jfrog.ProjectKey != "" {
+		params["project"] = jfrog.ProjectKey
+	}
+	if jfrog.BuildInfoRepo != "" {
+		params["buildRepo"] = jfrog.BuildInfoRepo
+	}
+	return params
+}
+
+// Send a GET request to an escaped Artifactory path, and decode the JSON response into result.
+// Returns false if the path doesn't exist.
+func getArtifactoryJson(servicesManager artifactory.ArtifactoryServicesManager, escapedPath string, params map[string]string, result interface{}) (bool, error) {
+	details := servicesManager.GetConfig().GetServiceDetails()
+	requestUrl, err := buildEscapedArtifactoryUrl(details.GetUrl(), escapedPath, params)
+	if err != nil {
+		return false, err
+	}
+	httpClientDetails := details.CreateHttpClientDetails()
+	resp, body, _, err := servicesManager.Client().SendGet(requestUrl, true, &httpClientDetails)
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, json.Unmarshal(body, result)
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("Artifactory response: %s %s", resp.Status, string(body))
+}
+
+// Unlike servicesutils.BuildArtifactoryUrl, the path is already escaped, so escaped '/' characters are kept.
+func buildEscapedArtifactoryUrl(artUrl, escapedPath string, params map[string]string) (string, error) {
+	requestUrl, err := url.Parse(artUrl + escapedPath)
+	if err != nil {
+		return "", err
+	}
+	query := requestUrl.Query()
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	requestUrl.RawQuery = query.Encode()
+	return requestUrl.String(), nil
+}
+
+// Returns the latest build-info file of buildName in the build-info repository, or nil if there is none.
+func readLatestBuildInfoFile(servicesManager artifactory.ArtifactoryServicesManager, buildName, repo string) (*buildinfo.BuildInfo, error) {
+	params := services.NewSearchParams()
+	params.Pattern = repo + "/" + buildName + "/*"
+	params.SortBy = []string{"created"}
+	params.SortOrder = "desc"
+	params.Limit = 1
+	reader, err := servicesManager.SearchFiles(params)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if reader.IsEmpty() {
+		return nil, nil
+	}
+	item := new(servicesutils.ResultItem)
+	if err = reader.NextRecord(item); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, reader.GetError()
+		}
+		return nil, err
+	}
+	content, err := servicesManager.ReadRemoteFile(item.GetItemRelativePath())
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	bi := new(buildinfo.BuildInfo)
+	return bi, json.NewDecoder(content).Decode(bi)
+}
+
+// Format properties in the 'key1=value1;key2=value2' format of jfrog-client-go, escaping the values for the way they are parsed.
+// The values of uploaded files, parsed with 'SplitCommas', are split by ',', which is escaped by '\'.
+// The properties are split by ';', which can't be escaped, so it is URL encoded.
+func formatProps(option servicesutils.PropertyParseOptions, props ...servicesutils.Property) string {
+	formatted := make([]string, 0, len(props))
+	for _, prop := range props {
+		value := strings.Replace(prop.Value, ";", url.QueryEscape(";"), -1)
+		if option == servicesutils.SplitCommas {
+			value = strings.Replace(value, ",", "\\,", -1)
+		}
+		formatted = append(formatted, prop.Key+"="+value)
+	}
+	return strings.Join(formatted, ";")
+}
+
+// Set properties, in the 'key1=value1;key2=value2' format, on the build-info file of a build number. Format them with formatProps and 'JoinCommas'.
+func setBuildInfoProps(servicesManager artifactory.ArtifactoryServicesManager, buildName, buildNumber, props string, jfrog *JfrogDetails) error {
+	params := services.NewSearchParams()
+	params.Pattern = getBuildInfoRepo(jfrog) + "/" + buildName + "/" + buildNumber + "-*.json"
+	return Retry("Setting properties of build '"+buildName+"/"+buildNumber+"'", func() error {
+		reader, err := servicesManager.SearchFiles(params)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		propsParams := services.NewPropsParams()
+		propsParams.Reader = reader
+		propsParams.Props = props
+		count, err := servicesManager.SetProps(propsParams)
+		if err == nil && count == 0 {
+			// The build-info file may not be searchable yet right after the build is published.
+			err = &transientError{fmt.Errorf("the build-info file of build '%s/%s' is not found in '%s'", buildName, buildNumber, getBuildInfoRepo(jfrog))}
+		}
+		return err
+	})
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	servicesutils "github.com/jfrog/jfrog-client-go/artifactory/services/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLatestBuildInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		switch r.URL.Path {
+		case "/api/build/my-build":
+			body = `{"buildsNumbers":[{"uri":"/10","started":"2021-01-10T10:00:00.000+0000"},{"uri":"/9","started":"2021-01-11T10:00:00.000+0200"},{"uri":"/8","started":"2021-01-09T10:00:00.000+0000"}]}`
+		case "/api/build/my-build/9":
+			body = `{"buildInfo":{"name":"my-build","number":"9","vcs":[{"url":"https://git/repo.git","revision":"abc"}]}}`
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err := w.Write([]byte(body))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+	servicesManager := createTestServicesManager(t, server)
+
+	// The latest started build is returned, regardless of its number.
+	bi, err := GetLatestBuildInfo(servicesManager, "my-build", &JfrogDetails{})
+	assert.NoError(t, err)
+	assert.Equal(t, "9", bi.Number)
+	sha, err := getBuildCommitSha(bi, "https://git/repo.git")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", sha)
+
+	exists, err := IsBuildExists(servicesManager, "my-build", &JfrogDetails{})
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	bi, err = GetLatestBuildInfo(servicesManager, "missing", &JfrogDetails{})
+	assert.NoError(t, err)
+	assert.Nil(t, bi)
+	exists, err = IsBuildExists(servicesManager, "missing", &JfrogDetails{})
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestGetLatestBuildInfoEscaping(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		var body string
+		switch r.URL.EscapedPath() {
+		case "/api/build/team%2Fmy%20build":
+			body = `{"buildsNumbers":[{"uri":"/1 rc","started":"2021-01-10T10:00:00.000+0000"}]}`
+		case "/api/build/team%2Fmy%20build/1%20rc":
+			body = `{"buildInfo":{"name":"team/my build","number":"1 rc"}}`
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err := w.Write([]byte(body))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	// The build name and number are escaped as single path segments.
+	bi, err := GetLatestBuildInfo(createTestServicesManager(t, server), "team/my build", &JfrogDetails{})
+	assert.NoError(t, err)
+	if assert.NotNil(t, bi) {
+		assert.Equal(t, "1 rc", bi.Number)
+	}
+	assert.Equal(t, []string{"/api/build/team%2Fmy%20build", "/api/build/team%2Fmy%20build/1%20rc"}, paths)
+}
+
+func TestGetLatestBuildInfoCustomRepo(t *testing.T) {
+	var buildRepos []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		switch r.URL.Path {
+		case "/api/build/my-build":
+			// An Artifactory version which doesn't support custom build-info repositories.
+			buildRepos = append(buildRepos, r.URL.Query().Get("buildRepo"))
+			body = `{"buildsNumbers":[]}`
+		case "/api/search/aql":
+			body = `{"results":[{"repo":"my-build-info","path":"my-build","name":"3-1610000000000.json","type":"file"}]}`
+		case "/my-build-info/my-build/3-1610000000000.json":
+			body = `{"name":"my-build","number":"3"}`
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err := w.Write([]byte(body))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	bi, err := GetLatestBuildInfo(createTestServicesManager(t, server), "my-build", &JfrogDetails{BuildInfoRepo: "my-build-info"})
+	assert.NoError(t, err)
+	assert.Equal(t, "3", bi.Number)
+	assert.Equal(t, []string{"my-build-info"}, buildRepos)
+}
+
+func TestGetLatestBuildInfoProject(t *testing.T) {
+	var projects []string
+	var aql string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		switch r.URL.Path {
+		case "/api/build/my-build":
+			projects = append(projects, r.URL.Query().Get("project"))
+			body = `{"buildsNumbers":[{"uri":"/1","started":"2021-01-10T10:00:00.000+0000"}]}`
+		case "/api/build/my-build/1":
+			projects = append(projects, r.URL.Query().Get("project"))
+			body = `{"buildInfo":{"name":"my-build","number":"1"}}`
+		case "/api/search/aql":
+			content, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			aql = string(content)
+			body = `{"results":[]}`
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err := w.Write([]byte(body))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+	servicesManager := createTestServicesManager(t, server)
+
+	bi, err := GetLatestBuildInfo(servicesManager, "my-build", &JfrogDetails{ProjectKey: "proj"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", bi.Number)
+	assert.Equal(t, []string{"proj", "proj"}, projects)
+
+	// The project build-info repository is searched when the build API returns no builds.
+	exists, err := IsBuildExists(servicesManager, "other-build", &JfrogDetails{ProjectKey: "proj"})
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.Contains(t, aql, `"repo":"proj-build-info"`)
+}
+
+func TestGetBuildInfoRepo(t *testing.T) {
+	assert.Equal(t, "artifactory-build-info", getBuildInfoRepo(&JfrogDetails{}))
+	assert.Equal(t, "proj-build-info", getBuildInfoRepo(&JfrogDetails{ProjectKey: "proj"}))
+	assert.Equal(t, "custom-build-info", getBuildInfoRepo(&JfrogDetails{ProjectKey: "proj", BuildInfoRepo: "custom-build-info"}))
+}
+
+func TestFormatProps(t *testing.T) {
+	props := []servicesutils.Property{{Key: "build.name", Value: "my-build"}, {Key: "vcs.branch", Value: "feature/a,b;c"}}
+	// The values of uploaded files are split by commas.
+	formatted := formatProps(servicesutils.SplitCommas, props...)
+	assert.Equal(t, `build.name=my-build;vcs.branch=feature/a\,b%3Bc`, formatted)
+	parsed, err := servicesutils.ParseProperties(formatted, servicesutils.SplitCommas)
+	assert.NoError(t, err)
+	assert.Equal(t, []servicesutils.Property{{Key: "build.name", Value: "my-build"}, {Key: "vcs.branch", Value: "feature/a,b%3Bc"}}, parsed.Properties)
+
+	formatted = formatProps(servicesutils.JoinCommas, props...)
+	assert.Equal(t, "build.name=my-build;vcs.branch=feature/a,b%3Bc", formatted)
+	parsed, err = servicesutils.ParseProperties(formatted, servicesutils.JoinCommas)
+	assert.NoError(t, err)
+	assert.Equal(t, []servicesutils.Property{{Key: "build.name", Value: "my-build"}, {Key: "vcs.branch", Value: "feature/a,b%3Bc"}}, parsed.Properties)
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Characters which are not allowed in an Artifactory build name.
+	invalidBuildNameChars = `/\:|?*"<>`
+	// The prefix of a build name variable which is resolved from an environment variable.
+	envVarPrefix = "env:"
+)
+
+// Matches a build name variable, such as '${branch}' or '${branch|sanitize|truncate:20}'.
+var buildNameVarRegexp = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// Render a build name template and validate the result.
+// A variable is either one of 'vars' or '${env:X}' for the value of the environment variable X, which must be set.
+// A variable may be followed by filters, separated by '|':
+// sanitize - replace characters which are not allowed in build names with '-'.
+// lower - convert to lower case.
+// truncate:N - keep only the first N characters.
+func renderBuildName(template string, vars map[string]string) (string, error) {
+	buildName, err := renderTemplate(template, vars)
+	if err != nil {
+		return "", err
+	}
+	return buildName, validateBuildName(buildName)
+}
+
+// Replace the variables in a template. See renderBuildName for the template syntax.
+func renderTemplate(template string, vars map[string]string) (string, error) {
+	var renderErr error
+	result := buildNameVarRegexp.ReplaceAllStringFunc(template, func(match string) string {
+		value, err := resolveBuildNameVar(buildNameVarRegexp.FindStringSubmatch(match)[1], vars)
+		if err != nil && renderErr == nil {
+			renderErr = fmt.Errorf("failed to render '%s': %s", template, err.Error())
+		}
+		return value
+	})
+	return result, renderErr
+}
+
+func resolveBuildNameVar(expression string, vars map[string]string) (string, error) {
+	parts := strings.Split(expression, "|")
+	name := strings.TrimSpace(parts[0])
+	var value string
+	if strings.HasPrefix(name, envVarPrefix) {
+		envVar := strings.TrimPrefix(name, envVarPrefix)
+		var ok bool
+		if value, ok = os.LookupEnv(envVar); !ok {
+			return "", fmt.Errorf("environment variable '%s' is not set", envVar)
+		}
+	} else {
+		var ok bool
+		if value, ok = vars[name]; !ok {
+			return "", fmt.Errorf("unknown variable '%s'", name)
+		}
+	}
+	for _, filter := range parts[1:] {
+		var err error
+		if value, err = applyBuildNameFilter(strings.TrimSpace(filter), value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+func applyBuildNameFilter(filter, value string) (string, error) {
+	switch {
+	case filter == "sanitize":
+		return strings.Map(func(r rune) rune {
+			if strings.ContainsRune(invalidBuildNameChars, r) {
+				return '-'
+			}
+			return r
+		}, value), nil
+	case filter == "lower":
+		return strings.ToLower(value), nil
+	case strings.HasPrefix(filter, "truncate:"):
+		length, err := strconv.Atoi(strings.TrimPrefix(filter, "truncate:"))
+		if err != nil || length < 0 {
+			return "", fmt.Errorf("invalid filter '%s', expecting 'truncate:<length>'", filter)
+		}
+		if runes := []rune(value); len(runes) > length {
+			return string(runes[:length]), nil
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown filter '%s'", filter)
+}
+
+// Validate a build name against Artifactory's build name rules.
+func validateBuildName(buildName string) error {
+	if strings.TrimSpace(buildName) == "" {
+		return fmt.Errorf("build name must not be empty")
+	}
+	if idx := strings.IndexAny(buildName, invalidBuildNameChars); idx >= 0 {
+		return fmt.Errorf("build name '%s' contains the invalid character '%c'. Use the 'sanitize' filter, for example '${branch|sanitize}'", buildName, buildName[idx])
+	}
+	return nil
+}
+
+// Returns the repository name of a VCS URL, such as 'npm-example' for 'https://github.com/Or-Geva/npm-example.git'.
+func getRepoName(vcsUrl string) string {
+	repoPath := vcsUrl
+	if u, err := url.Parse(vcsUrl); err == nil && u.Path != "" {
+		repoPath = u.Path
+	} else if idx := strings.LastIndex(vcsUrl, ":"); idx >= 0 {
+		// scp-like syntax, such as 'git@github.com:Or-Geva/npm-example.git'.
+		repoPath = vcsUrl[idx+1:]
+	}
+	return strings.TrimSuffix(path.Base(strings.TrimSuffix(repoPath, "/")), ".git")
+}
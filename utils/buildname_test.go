@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderBuildName(t *testing.T) {
+	assert.NoError(t, os.Setenv("VCS_AGENT_TEST_ENV", "Prod"))
+	defer func() { assert.NoError(t, os.Unsetenv("VCS_AGENT_TEST_ENV")) }()
+	vars := map[string]string{"projectName": "npm-example", "module": "web", "branch": "feature/JIRA-123-a-long-description"}
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"${projectName}-${module}", "npm-example-web"},
+		{"${projectName}-${branch|sanitize}", "npm-example-feature-JIRA-123-a-long-description"},
+		{"${branch | sanitize | lower | truncate:15}", "feature-jira-12"},
+		{"${projectName}-${env:VCS_AGENT_TEST_ENV|lower}", "npm-example-prod"},
+	}
+	for _, test := range tests {
+		buildName, err := renderBuildName(test.template, vars)
+		assert.NoError(t, err, test.template)
+		assert.Equal(t, test.expected, buildName, test.template)
+	}
+	for _, invalid := range []string{"${unknown}", "${branch|upper}", "${branch|sanitize|truncate:x}", "${branch}", "${module|truncate:0}"} {
+		_, err := renderBuildName(invalid, vars)
+		assert.Error(t, err, invalid)
+	}
+	_, err := renderBuildName("${projectName}-${env:VCS_AGENT_TEST_MISSING}", vars)
+	assert.EqualError(t, err, "failed to render '${projectName}-${env:VCS_AGENT_TEST_MISSING}': environment variable 'VCS_AGENT_TEST_MISSING' is not set")
+}
+
+func TestGetRepoName(t *testing.T) {
+	for _, vcsUrl := range []string{
+		"https://github.com/Or-Geva/npm-example.git",
+		"https://github.com/Or-Geva/npm-example/",
+		"git@github.com:Or-Geva/npm-example.git",
+		"ssh://git@github.com/Or-Geva/npm-example",
+	} {
+		assert.Equal(t, "npm-example", getRepoName(vcsUrl), vcsUrl)
+	}
+}
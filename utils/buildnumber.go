@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBuildNumberScheme   = "default"
+	MonotonicBuildNumberScheme = "monotonic"
+	TimestampBuildNumberScheme = "timestamp"
+	SemverBuildNumberScheme    = "semver-from-tag"
+	TemplateBuildNumberScheme  = "template"
+
+	// Time format of the timestamp based build numbers.
+	buildNumberTimeFormat = "20060102150405"
+)
+
+// Matches the leading number of a previous build number, such as '12' in '12.3-abcdef12'.
+var prevBuildNumberRegexp = regexp.MustCompile(`^\s*(\d+)`)
+
+// The details of the commit a build number is generated for.
+type BuildNumberParams struct {
+	// The build number of the latest build of the branch. Empty if there are no previous builds.
+	PrevBuildNumber string
+	// The index of the commit among the commits scanned in this run.
+	RunNumber int
+	// The short commit sha.
+	CommitSha string
+	// The tag name, for tag builds.
+	Tag string
+}
+
+// Generates the build numbers published by the agent.
+type BuildNumberScheme interface {
+	GetBuildNumber(params *BuildNumberParams) (string, error)
+}
+
+// Create the build number scheme configured in 'JfrogDetails.BuildNumberScheme':
+// default - '<next>.<run>-<sha>', for example '5.0-abcdef12'.
+// monotonic - an integer incremented by 1 for every build, for example '5'.
+// timestamp - '<UTC time>-<sha>', for example '20210315093000-abcdef12'.
+// semver-from-tag - the semantic version of the tag, for example '1.2.3'. Branch builds use the default scheme.
+// template - 'JfrogDetails.BuildNumberTemplate', with the ${next}, ${run}, ${sha}, ${tag} and ${timestamp} variables.
+func NewBuildNumberScheme(j *JfrogDetails) (BuildNumberScheme, error) {
+	switch j.BuildNumberScheme {
+	case "", DefaultBuildNumberScheme:
+		return &defaultBuildNumberScheme{}, nil
+	case MonotonicBuildNumberScheme:
+		return &monotonicBuildNumberScheme{}, nil
+	case TimestampBuildNumberScheme:
+		return &timestampBuildNumberScheme{now: time.Now}, nil
+	case SemverBuildNumberScheme:
+		return &semverBuildNumberScheme{}, nil
+	case TemplateBuildNumberScheme:
+		if j.BuildNumberTemplate == "" {
+			return nil, fmt.Errorf("the '%s' build number scheme requires 'buildNumberTemplate'", TemplateBuildNumberScheme)
+		}
+		return &templateBuildNumberScheme{template: j.BuildNumberTemplate, now: time.Now}, nil
+	}
+	return nil, fmt.Errorf("unknown build number scheme '%s'", j.BuildNumberScheme)
+}
+
+type defaultBuildNumberScheme struct{}
+
+func (s *defaultBuildNumberScheme) GetBuildNumber(params *BuildNumberParams) (string, error) {
+	next, err := GetNextBuildNumber(params.PrevBuildNumber)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%d-%s", next, params.RunNumber, params.CommitSha), nil
+}
+
+type monotonicBuildNumberScheme struct{}
+
+// All the commits of a run share the same previous build number, so the run number is added to it.
+func (s *monotonicBuildNumberScheme) GetBuildNumber(params *BuildNumberParams) (string, error) {
+	next, err := GetNextBuildNumber(params.PrevBuildNumber)
+	if err != nil {
+		return "", err
+	}
+	bn, err := strconv.Atoi(next)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(bn + params.RunNumber), nil
+}
+
+type timestampBuildNumberScheme struct {
+	now func() time.Time
+}
+
+func (s *timestampBuildNumberScheme) GetBuildNumber(params *BuildNumberParams) (string, error) {
+	return s.now().UTC().Format(buildNumberTimeFormat) + "-" + params.CommitSha, nil
+}
+
+type semverBuildNumberScheme struct{}
+
+func (s *semverBuildNumberScheme) GetBuildNumber(params *BuildNumberParams) (string, error) {
+	if params.Tag == "" {
+		return new(defaultBuildNumberScheme).GetBuildNumber(params)
+	}
+	version, err := parseSemVersion(params.Tag)
+	if err != nil {
+		return "", fmt.Errorf("the '%s' build number scheme requires semantic version tags: %s", SemverBuildNumberScheme, err.Error())
+	}
+	return version.String(), nil
+}
+
+type templateBuildNumberScheme struct {
+	template string
+	now      func() time.Time
+}
+
+func (s *templateBuildNumberScheme) GetBuildNumber(params *BuildNumberParams) (string, error) {
+	next, err := GetNextBuildNumber(params.PrevBuildNumber)
+	if err != nil {
+		return "", err
+	}
+	buildNumber, err := renderTemplate(s.template, map[string]string{
+		"next":      next,
+		"run":       strconv.Itoa(params.RunNumber),
+		"sha":       params.CommitSha,
+		"tag":       params.Tag,
+		"timestamp": s.now().UTC().Format(buildNumberTimeFormat),
+	})
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(buildNumber) == "" {
+		return "", fmt.Errorf("build number template '%s' resulted in an empty build number", s.template)
+	}
+	return buildNumber, nil
+}
+
+// Return the value of 'BUILD_NUMBER' env var.
+// If not configured, return the leading number of the last run build number incremented by 1, or 1 if there is no previous build.
+func GetNextBuildNumber(prevBuildNumber string) (string, error) {
+	if fromEnv := os.Getenv(buildNumber); fromEnv != "" {
+		bn, err := strconv.Atoi(fromEnv)
+		if err != nil {
+			return "", fmt.Errorf("environment variable '%s' must be a number, got '%s'", buildNumber, fromEnv)
+		}
+		return strconv.Itoa(bn), nil
+	}
+	if strings.TrimSpace(prevBuildNumber) == "" {
+		// First build.
+		return "1", nil
+	}
+	match := prevBuildNumberRegexp.FindStringSubmatch(prevBuildNumber)
+	if match == nil {
+		return "", fmt.Errorf("failed to parse the previous build number '%s', expecting it to start with a number", prevBuildNumber)
+	}
+	bn, err := strconv.Atoi(match[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the previous build number '%s': %s", prevBuildNumber, err.Error())
+	}
+	return strconv.Itoa(bn + 1), nil
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetNextBuildNumber(t *testing.T) {
+	tests := []struct {
+		prevBuildNumber string
+		expected        string
+	}{
+		{"", "1"},
+		{"4.2-abcdef12", "5"},
+		{"4", "5"},
+		{"4-abcdef12", "5"},
+		{" 12abc", "13"},
+	}
+	for _, test := range tests {
+		next, err := GetNextBuildNumber(test.prevBuildNumber)
+		assert.NoError(t, err, test.prevBuildNumber)
+		assert.Equal(t, test.expected, next, test.prevBuildNumber)
+	}
+	for _, malformed := range []string{"abc", "-1", ".4", "v1.2.3", "99999999999999999999999"} {
+		_, err := GetNextBuildNumber(malformed)
+		assert.Error(t, err, malformed)
+	}
+}
+
+func TestGetNextBuildNumberFromEnv(t *testing.T) {
+	assert.NoError(t, os.Setenv(buildNumber, "42"))
+	defer func() { assert.NoError(t, os.Unsetenv(buildNumber)) }()
+	next, err := GetNextBuildNumber("not-a-number")
+	assert.NoError(t, err)
+	assert.Equal(t, "42", next)
+	assert.NoError(t, os.Setenv(buildNumber, "x"))
+	_, err = GetNextBuildNumber("")
+	assert.Error(t, err)
+}
+
+func TestBuildNumberSchemes(t *testing.T) {
+	now := func() time.Time { return time.Date(2021, 3, 15, 9, 30, 0, 0, time.UTC) }
+	params := &BuildNumberParams{PrevBuildNumber: "4.2-12345678", RunNumber: 2, CommitSha: "abcdef12"}
+	tagParams := &BuildNumberParams{CommitSha: "abcdef12", Tag: "v1.2.3"}
+	tests := []struct {
+		scheme   BuildNumberScheme
+		params   *BuildNumberParams
+		expected string
+	}{
+		{&defaultBuildNumberScheme{}, params, "5.2-abcdef12"},
+		{&monotonicBuildNumberScheme{}, params, "7"},
+		{&timestampBuildNumberScheme{now: now}, params, "20210315093000-abcdef12"},
+		{&semverBuildNumberScheme{}, tagParams, "1.2.3"},
+		{&semverBuildNumberScheme{}, params, "5.2-abcdef12"},
+		{&templateBuildNumberScheme{template: "${next}-${timestamp}-${sha}", now: now}, params, "5-20210315093000-abcdef12"},
+		{&templateBuildNumberScheme{template: "${tag}+${run}", now: now}, tagParams, "v1.2.3+0"},
+	}
+	for _, test := range tests {
+		buildNumber, err := test.scheme.GetBuildNumber(test.params)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, buildNumber)
+	}
+}
+
+func TestBuildNumberSchemesMalformedInput(t *testing.T) {
+	now := func() time.Time { return time.Now() }
+	params := &BuildNumberParams{PrevBuildNumber: "latest", CommitSha: "abcdef12"}
+	for _, scheme := range []BuildNumberScheme{&defaultBuildNumberScheme{}, &monotonicBuildNumberScheme{}, &templateBuildNumberScheme{template: "${next}", now: now}} {
+		_, err := scheme.GetBuildNumber(params)
+		assert.Error(t, err)
+	}
+	_, err := new(semverBuildNumberScheme).GetBuildNumber(&BuildNumberParams{Tag: "nightly"})
+	assert.Error(t, err)
+	_, err = (&templateBuildNumberScheme{template: "${env:VCS_AGENT_TEST_MISSING}", now: now}).GetBuildNumber(&BuildNumberParams{})
+	assert.Error(t, err)
+}
+
+func TestNewBuildNumberScheme(t *testing.T) {
+	scheme, err := NewBuildNumberScheme(&JfrogDetails{})
+	assert.NoError(t, err)
+	assert.IsType(t, &defaultBuildNumberScheme{}, scheme)
+	_, err = NewBuildNumberScheme(&JfrogDetails{BuildNumberScheme: TemplateBuildNumberScheme})
+	assert.Error(t, err)
+	_, err = NewBuildNumberScheme(&JfrogDetails{BuildNumberScheme: "random"})
+	assert.Error(t, err)
+}
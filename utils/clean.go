@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+// The maximum number of paths listed in the error of a worktree which doesn't match the commit.
+const maxListedDirtyPaths = 10
+
+// Remove the files left by the build of the previous commit, and verify the worktree exactly matches the checked out commit.
+// The untracked and ignored paths matching 'vcs.CleanExclude', such as dependency caches, are kept. Does nothing unless 'vcs.Clean' is set.
+func CleanWorktree(b GitBackend, vcs *Vcs) error {
+	if !vcs.Clean {
+		return nil
+	}
+	log.Info("Cleaning the untracked and ignored files of the worktree...")
+	if err := b.Clean(vcs.CleanExclude); err != nil {
+		return err
+	}
+	dirty, err := b.DirtyPaths(vcs.CleanExclude)
+	if err != nil || len(dirty) == 0 {
+		return err
+	}
+	listed := dirty
+	if len(listed) > maxListedDirtyPaths {
+		listed = listed[:maxListedDirtyPaths]
+	}
+	message := strings.Join(listed, ", ")
+	if len(dirty) > len(listed) {
+		message += fmt.Sprintf(" and %d more", len(dirty)-len(listed))
+	}
+	return fmt.Errorf("the worktree doesn't match the checked out commit after cleaning: %s", message)
+}
+
+// Returns a matcher of gitignore patterns, relative to the worktree root.
+func newExcludeMatcher(excludes []string) gitignore.Matcher {
+	var patterns []gitignore.Pattern
+	for _, exclude := range excludes {
+		patterns = append(patterns, gitignore.ParsePattern(exclude, nil))
+	}
+	return gitignore.NewMatcher(patterns)
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanWorktree(t *testing.T) {
+	remote, remotePath := createRepoWithFiles(t, map[string]string{".gitignore": "build/\nnode_modules/\n", "a.txt": "1"})
+	head, err := remote.Head()
+	assert.NoError(t, err)
+	for _, backend := range []string{GoGitBackend, CliGitBackend} {
+		t.Run(backend, func(t *testing.T) {
+			vcs := &Vcs{Url: remotePath, GitBackend: backend, Clean: true, CleanExclude: []string{"node_modules/"}}
+			b := cloneToTempDir(t, vcs)
+			assert.NoError(t, CheckoutHash(head.Hash().String(), b))
+			path := getRepoPath(t, repository(t, b))
+			for name, content := range map[string]string{
+				"a.txt":                     "modified",
+				"untracked.txt":             "1",
+				"build/classes/App.class":   "1",
+				"node_modules/dep/index.js": "1",
+				"src/node_modules/dep.js":   "1",
+			} {
+				assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(path, name)), 0755))
+				assert.NoError(t, ioutil.WriteFile(filepath.Join(path, name), []byte(content), 0644))
+			}
+
+			dirty, err := b.DirtyPaths(vcs.CleanExclude)
+			assert.NoError(t, err)
+			assert.Contains(t, dirty, "a.txt")
+			assert.Contains(t, dirty, "untracked.txt")
+			assert.NotContains(t, dirty, "node_modules/")
+			assert.NotContains(t, dirty, "node_modules/dep/index.js")
+
+			// Cleaning doesn't restore the tracked files.
+			err = CleanWorktree(b, vcs)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "a.txt")
+			}
+			assert.NoFileExists(t, filepath.Join(path, "untracked.txt"))
+			assert.NoDirExists(t, filepath.Join(path, "build"))
+			assert.FileExists(t, filepath.Join(path, "node_modules/dep/index.js"))
+			assert.FileExists(t, filepath.Join(path, "src/node_modules/dep.js"))
+
+			assert.NoError(t, CheckoutHash(head.Hash().String(), b))
+			assert.NoError(t, CleanWorktree(b, vcs))
+
+			// The worktree isn't cleaned unless 'Clean' is set.
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(path, "untracked.txt"), []byte("1"), 0644))
+			assert.NoError(t, CleanWorktree(b, &Vcs{}))
+			assert.FileExists(t, filepath.Join(path, "untracked.txt"))
+		})
+	}
+}
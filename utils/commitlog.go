@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jfrog/jfrog-client-go/artifactory"
+	"github.com/jfrog/jfrog-client-go/artifactory/services"
+	servicesutils "github.com/jfrog/jfrog-client-go/artifactory/services/utils"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+const (
+	// The property of the build-info files which holds the URL of the archived commit log.
+	commitLogProperty = "vcs-agent.log"
+	// The property of the archived commit logs which holds the result of the commit scan.
+	commitStatusProperty = "vcs-agent.status"
+)
+
+// The results of a commit scan, stored on its archived log.
+const (
+	// The commit was built, published and scanned. Violations found by Xray don't fail the scan.
+	CommitScanned = "scanned"
+	// The build command failed, and the commit was skipped.
+	CommitBuildFailed = "build-failed"
+	// The commit failed in another stage, such as publish or scan.
+	CommitFailed = "failed"
+)
+
+// The log of the current commit, which the command outputs are written to. Nil if commit logs aren't archived.
+var commitLogWriter io.Writer
+
+// The output of the commands of a commit scan, archived to the 'BuildLogsRepo' generic repository.
+type CommitLog struct {
+	file        *os.File
+	buildName   string
+	buildNumber string
+	revision    string
+}
+
+// Start writing the command outputs into the log of a commit. Returns nil if 'jfrog.BuildLogsRepo' isn't set.
+func StartCommitLog(buildName, buildNumber, revision string, jfrog *JfrogDetails) (*CommitLog, error) {
+	if jfrog.BuildLogsRepo == "" {
+		return nil, nil
+	}
+	file, err := ioutil.TempFile("", "commit-log-*.log")
+	if err != nil {
+		return nil, err
+	}
+	commitLogWriter = file
+	return &CommitLog{file: file, buildName: buildName, buildNumber: buildNumber, revision: revision}, nil
+}
+
+// Stop writing to the commit log, upload it to '<BuildLogsRepo>/<buildName>/<buildNumber>.log' and delete the local file.
+// The log is linked to the build, and to the commit revision, with properties. If the build was published, its build-info file links to the log.
+func (l *CommitLog) Archive(servicesManager artifactory.ArtifactoryServicesManager, status string, published bool, jfrog *JfrogDetails) error {
+	if l == nil {
+		return nil
+	}
+	commitLogWriter = nil
+	defer os.Remove(l.file.Name())
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	target := jfrog.BuildLogsRepo + "/" + l.buildName + "/" + l.buildNumber + ".log"
+	params := services.NewUploadParams()
+	params.Pattern = l.file.Name()
+	params.Target = target
+	params.Flat = true
+	params.TargetProps = formatProps(servicesutils.SplitCommas,
+		servicesutils.Property{Key: "build.name", Value: l.buildName},
+		servicesutils.Property{Key: "build.number", Value: l.buildNumber},
+		servicesutils.Property{Key: "vcs.revision", Value: l.revision},
+		servicesutils.Property{Key: commitStatusProperty, Value: status})
+	err := Retry("Uploading the log of commit '"+l.revision+"'", func() error {
+		uploaded, _, err := servicesManager.UploadFiles(params)
+		if err == nil && uploaded == 0 {
+			err = fmt.Errorf("failed to upload the log of commit '%s' to '%s'", l.revision, target)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	logUrl := strings.TrimSuffix(jfrog.ArtUrl, "/") + "/" + target
+	log.Info("The log of commit '" + l.revision + "' is archived at '" + logUrl + "'")
+	if !published {
+		return nil
+	}
+	props := formatProps(servicesutils.JoinCommas, servicesutils.Property{Key: commitLogProperty, Value: logUrl})
+	return setBuildInfoProps(servicesManager, l.buildName, l.buildNumber, props, jfrog)
+}
+
+// Returns the writers of the output of a command, which also write to the commit log if it is archived.
+// A header with the stage of the command separates the outputs of the commands.
+func withCommitLog(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	if commitLogWriter == nil {
+		return stdout, stderr
+	}
+	if stage := getLogContext().Stage; stage != "" {
+		_, _ = fmt.Fprintf(commitLogWriter, "===== %s =====\n", stage)
+	}
+	return io.MultiWriter(stdout, commitLogWriter), io.MultiWriter(stderr, commitLogWriter)
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveCommitLog(t *testing.T) {
+	uploads := map[string]string{}
+	var props []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/my-logs/"):
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			uploads[r.URL.Path] = string(body)
+			w.WriteHeader(http.StatusCreated)
+		case r.URL.Path == "/api/search/aql":
+			_, err := w.Write([]byte(`{"results":[{"repo":"artifactory-build-info","path":"my-build","name":"3-1610000000000.json","type":"file"}]}`))
+			assert.NoError(t, err)
+		case strings.HasPrefix(r.URL.Path, "/api/storage/"):
+			props = append(props, r.URL.Path+"?"+r.URL.Query().Get("properties"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	servicesManager := createTestServicesManager(t, server)
+	jfrog := &JfrogDetails{ArtUrl: server.URL + "/", BuildLogsRepo: "my-logs"}
+	defer SetLogContext("", "", "")
+
+	commitLog, err := StartCommitLog("my-build", "3", "abc", jfrog)
+	assert.NoError(t, err)
+	SetLogStage(BuildStage)
+	assert.NoError(t, RunCmd("", "echo out; echo err >&2"))
+	assert.NoError(t, commitLog.Archive(servicesManager, CommitScanned, true, jfrog))
+	assert.Nil(t, commitLogWriter)
+
+	// The properties are sent as matrix parameters of the upload path.
+	expectedPath := "/my-logs/my-build/3.log;build.name=my-build;build.number=3;vcs.revision=abc;vcs-agent.status=scanned;"
+	if assert.Contains(t, uploads, expectedPath) {
+		assert.Contains(t, uploads[expectedPath], "===== build =====\n")
+		assert.Contains(t, uploads[expectedPath], "out\n")
+		assert.Contains(t, uploads[expectedPath], "err\n")
+	}
+	assert.Equal(t, []string{"/api/storage/artifactory-build-info/my-build/3-1610000000000.json?vcs-agent.log=" + server.URL + "/my-logs/my-build/3.log"}, props)
+
+	// A commit which wasn't published only has the log properties.
+	commitLog, err = StartCommitLog("my-build", "4", "def", jfrog)
+	assert.NoError(t, err)
+	assert.NoError(t, commitLog.Archive(servicesManager, CommitBuildFailed, false, jfrog))
+	assert.Contains(t, uploads, "/my-logs/my-build/4.log;build.name=my-build;build.number=4;vcs.revision=def;vcs-agent.status=build-failed;")
+	assert.Len(t, props, 1)
+
+	// Logs aren't archived without a repository.
+	commitLog, err = StartCommitLog("my-build", "5", "ghi", &JfrogDetails{})
+	assert.NoError(t, err)
+	assert.Nil(t, commitLog)
+	assert.NoError(t, commitLog.Archive(servicesManager, CommitScanned, true, jfrog))
+}
@@ -0,0 +1,254 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+const (
+	GitHubProvider    = "github"
+	GitLabProvider    = "gitlab"
+	BitbucketProvider = "bitbucket"
+
+	defaultCommitStatusContext = "jfrog/xray"
+)
+
+// The state of a commit scan, reported as a commit status.
+type CommitState string
+
+const (
+	CommitStatePending CommitState = "pending"
+	CommitStateSuccess CommitState = "success"
+	CommitStateFailure CommitState = "failure"
+	CommitStateError   CommitState = "error"
+)
+
+// Report the scan results to the VCS provider, as commit statuses.
+type CommitStatus struct {
+	// One of 'github', 'gitlab' or 'bitbucket' (Bitbucket Cloud). Detected from the host of the vcs URL when empty.
+	Provider string `yaml:"provider"`
+	// The REST API URL. Defaults to the API of the provider's cloud service, or of a self-hosted server on the vcs URL host.
+	ApiUrl string `yaml:"apiUrl"`
+	// The name of the status, shown next to the CI checks. Defaults to 'jfrog/xray'.
+	Context string `yaml:"context"`
+}
+
+// An update of the status of a commit.
+type CommitStatusUpdate struct {
+	State       CommitState
+	Description string
+	// A link to the build in Artifactory. Optional.
+	TargetUrl string
+}
+
+// A client of the commit statuses API of a VCS provider.
+type commitStatusClient interface {
+	setStatus(sha string, update *CommitStatusUpdate) error
+}
+
+// Reports the scan results of commits to the VCS provider.
+type CommitStatusReporter struct {
+	client commitStatusClient
+}
+
+// Returns a reporter according to 'vcs.CommitStatus', or nil if commit statuses aren't reported.
+// The provider API is authenticated with 'vcs.Token'.
+func NewCommitStatusReporter(vcs *Vcs) (*CommitStatusReporter, error) {
+	config := vcs.CommitStatus
+	if config == nil {
+		return nil, nil
+	}
+	host, repoPath := splitVcsUrl(vcs.Url)
+	if host == "" {
+		return nil, fmt.Errorf("commit statuses can't be reported for the local repository '%s'", vcs.Url)
+	}
+	provider := config.Provider
+	if provider == "" {
+		if provider = detectVcsProvider(host); provider == "" {
+			return nil, fmt.Errorf("the VCS provider of '%s' can't be detected, set 'commitStatus.provider'", vcs.Url)
+		}
+	}
+	context := config.Context
+	if context == "" {
+		context = defaultCommitStatusContext
+	}
+	apiUrl := strings.TrimSuffix(config.ApiUrl, "/")
+	var client commitStatusClient
+	switch provider {
+	case GitHubProvider:
+		if apiUrl == "" {
+			apiUrl = "https://" + host + "/api/v3"
+			if strings.EqualFold(host, "github.com") {
+				apiUrl = "https://api.github.com"
+			}
+		}
+		client = &gitHubStatusClient{apiUrl: apiUrl, repoPath: repoPath, context: context, token: vcs.Token}
+	case GitLabProvider:
+		if apiUrl == "" {
+			apiUrl = "https://" + host + "/api/v4"
+		}
+		client = &gitLabStatusClient{apiUrl: apiUrl, repoPath: repoPath, context: context, token: vcs.Token}
+	case BitbucketProvider:
+		if apiUrl == "" {
+			apiUrl = "https://api.bitbucket.org/2.0"
+		}
+		client = &bitbucketStatusClient{apiUrl: apiUrl, repoPath: repoPath, context: context, user: vcs.User, token: vcs.Token}
+	default:
+		return nil, fmt.Errorf("unknown VCS provider '%s', expecting one of: %s, %s, %s", provider, GitHubProvider, GitLabProvider, BitbucketProvider)
+	}
+	return &CommitStatusReporter{client: client}, nil
+}
+
+// Returns the provider of a vcs host, or an empty string if it's unknown.
+func detectVcsProvider(host string) string {
+	host = strings.ToLower(host)
+	switch {
+	case strings.Contains(host, "github"):
+		return GitHubProvider
+	case strings.Contains(host, "gitlab"):
+		return GitLabProvider
+	case host == "bitbucket.org":
+		return BitbucketProvider
+	}
+	return ""
+}
+
+// Set the status of a commit. A failure is logged and doesn't fail the scan.
+func (r *CommitStatusReporter) Report(sha string, update *CommitStatusUpdate) {
+	if r == nil {
+		return
+	}
+	err := Retry("Reporting the status of commit '"+sha+"'", func() error {
+		return r.client.setStatus(sha, update)
+	})
+	if err != nil {
+		log.Error("Failed to report the status of commit '" + sha + "': " + err.Error())
+	}
+}
+
+// Returns the link to a published build in Artifactory.
+func GetBuildUrl(buildName, buildNumber string, jfrog *JfrogDetails) string {
+	return strings.TrimSuffix(jfrog.ArtUrl, "/") + "/webapp/builds/" + url.PathEscape(buildName) + "/" + url.PathEscape(buildNumber)
+}
+
+// Uses the commit statuses API of GitHub and GitHub Enterprise.
+type gitHubStatusClient struct {
+	apiUrl   string
+	repoPath string
+	context  string
+	token    string
+}
+
+func (c *gitHubStatusClient) setStatus(sha string, update *CommitStatusUpdate) error {
+	body := map[string]string{
+		"state":       string(update.State),
+		"description": update.Description,
+		"context":     c.context,
+	}
+	if update.TargetUrl != "" {
+		body["target_url"] = update.TargetUrl
+	}
+	header := map[string]string{"Accept": "application/vnd.github.v3+json"}
+	if c.token != "" {
+		header["Authorization"] = "token " + c.token
+	}
+	return sendCommitStatus(c.apiUrl+"/repos/"+c.repoPath+"/statuses/"+sha, body, header)
+}
+
+// Uses the commit statuses API of GitLab.
+type gitLabStatusClient struct {
+	apiUrl   string
+	repoPath string
+	context  string
+	token    string
+}
+
+func (c *gitLabStatusClient) setStatus(sha string, update *CommitStatusUpdate) error {
+	state := string(update.State)
+	switch update.State {
+	case CommitStateFailure, CommitStateError:
+		state = "failed"
+	}
+	body := map[string]string{
+		"state":       state,
+		"description": update.Description,
+		"name":        c.context,
+	}
+	if update.TargetUrl != "" {
+		body["target_url"] = update.TargetUrl
+	}
+	header := map[string]string{}
+	if c.token != "" {
+		header["PRIVATE-TOKEN"] = c.token
+	}
+	return sendCommitStatus(c.apiUrl+"/projects/"+url.PathEscape(c.repoPath)+"/statuses/"+sha, body, header)
+}
+
+// Uses the commit build statuses API of Bitbucket Cloud.
+// The token is an app password of 'user', or an access token without a user.
+type bitbucketStatusClient struct {
+	apiUrl   string
+	repoPath string
+	context  string
+	user     string
+	token    string
+}
+
+var bitbucketStates = map[CommitState]string{
+	CommitStatePending: "INPROGRESS",
+	CommitStateSuccess: "SUCCESSFUL",
+	CommitStateFailure: "FAILED",
+	CommitStateError:   "FAILED",
+}
+
+func (c *bitbucketStatusClient) setStatus(sha string, update *CommitStatusUpdate) error {
+	body := map[string]string{
+		"state":       bitbucketStates[update.State],
+		"key":         c.context,
+		"name":        c.context,
+		"description": update.Description,
+	}
+	if update.TargetUrl != "" {
+		body["url"] = update.TargetUrl
+	}
+	header := map[string]string{}
+	switch {
+	case c.user != "":
+		header["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.user+":"+c.token))
+	case c.token != "":
+		header["Authorization"] = "Bearer " + c.token
+	}
+	return sendCommitStatus(c.apiUrl+"/repositories/"+c.repoPath+"/commit/"+sha+"/statuses/build", body, header)
+}
+
+// Post a commit status as JSON.
+func sendCommitStatus(statusUrl string, body, header map[string]string) error {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, statusUrl, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("commit status request failed: %s", resp.Status)
+	}
+	return nil
+}
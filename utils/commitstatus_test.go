@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type commitStatusRequest struct {
+	path   string
+	header http.Header
+	body   map[string]string
+}
+
+func createCommitStatusServer(t *testing.T) (*httptest.Server, *[]commitStatusRequest) {
+	var requests []commitStatusRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		body := map[string]string{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		requests = append(requests, commitStatusRequest{path: r.URL.EscapedPath(), header: r.Header, body: body})
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestReportCommitStatus(t *testing.T) {
+	setupRetryTest(t, &RetryPolicy{MaxAttempts: 1})
+	server, requests := createCommitStatusServer(t)
+	update := &CommitStatusUpdate{State: CommitStateFailure, Description: "Violations were found by Xray", TargetUrl: "https://acme.jfrog.io/artifactory/webapp/builds/my-build/3"}
+
+	// GitHub
+	reporter, err := NewCommitStatusReporter(&Vcs{Url: "git@github.com:jfrog/project.git", Token: "gh-token", CommitStatus: &CommitStatus{ApiUrl: server.URL + "/"}})
+	assert.NoError(t, err)
+	reporter.Report("abc123", update)
+	if assert.Len(t, *requests, 1) {
+		request := (*requests)[0]
+		assert.Equal(t, "/repos/jfrog/project/statuses/abc123", request.path)
+		assert.Equal(t, "token gh-token", request.header.Get("Authorization"))
+		assert.Equal(t, map[string]string{"state": "failure", "description": update.Description, "context": "jfrog/xray", "target_url": update.TargetUrl}, request.body)
+	}
+
+	// GitLab
+	reporter, err = NewCommitStatusReporter(&Vcs{Url: "https://gitlab.acme.com/group/sub/project.git", Token: "gl-token", CommitStatus: &CommitStatus{ApiUrl: server.URL, Context: "security"}})
+	assert.NoError(t, err)
+	reporter.Report("abc123", &CommitStatusUpdate{State: CommitStateError, Description: "The build failed"})
+	if assert.Len(t, *requests, 2) {
+		request := (*requests)[1]
+		assert.Equal(t, "/projects/group%2Fsub%2Fproject/statuses/abc123", request.path)
+		assert.Equal(t, "gl-token", request.header.Get("PRIVATE-TOKEN"))
+		assert.Equal(t, map[string]string{"state": "failed", "description": "The build failed", "name": "security"}, request.body)
+	}
+
+	// Bitbucket
+	reporter, err = NewCommitStatusReporter(&Vcs{Url: "https://bitbucket.org/jfrog/project", User: "dev", Token: "app-password", CommitStatus: &CommitStatus{ApiUrl: server.URL}})
+	assert.NoError(t, err)
+	reporter.Report("abc123", &CommitStatusUpdate{State: CommitStatePending, Description: "Building and scanning the commit"})
+	if assert.Len(t, *requests, 3) {
+		request := (*requests)[2]
+		assert.Equal(t, "/repositories/jfrog/project/commit/abc123/statuses/build", request.path)
+		assert.Equal(t, "Basic ZGV2OmFwcC1wYXNzd29yZA==", request.header.Get("Authorization"))
+		assert.Equal(t, "INPROGRESS", request.body["state"])
+		assert.Equal(t, "jfrog/xray", request.body["key"])
+	}
+}
+
+func TestReportCommitStatusFailure(t *testing.T) {
+	setupRetryTest(t, &RetryPolicy{MaxAttempts: 2})
+	server, requests := createFlakyServer(t, 5, http.StatusServiceUnavailable, "")
+	reporter, err := NewCommitStatusReporter(&Vcs{Url: "https://github.com/jfrog/project", CommitStatus: &CommitStatus{ApiUrl: server.URL}})
+	assert.NoError(t, err)
+	// A failure is only logged.
+	reporter.Report("abc123", &CommitStatusUpdate{State: CommitStateSuccess})
+	assert.Equal(t, 2, *requests)
+}
+
+func TestNewCommitStatusReporter(t *testing.T) {
+	reporter, err := NewCommitStatusReporter(&Vcs{Url: "https://github.com/jfrog/project"})
+	assert.NoError(t, err)
+	assert.Nil(t, reporter)
+	// Reporting without a reporter does nothing.
+	reporter.Report("abc123", &CommitStatusUpdate{State: CommitStateSuccess})
+
+	reporter, err = NewCommitStatusReporter(&Vcs{Url: "https://github.com/jfrog/project", CommitStatus: &CommitStatus{}})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.github.com", reporter.client.(*gitHubStatusClient).apiUrl)
+
+	reporter, err = NewCommitStatusReporter(&Vcs{Url: "https://github.acme.com/jfrog/project", CommitStatus: &CommitStatus{}})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://github.acme.com/api/v3", reporter.client.(*gitHubStatusClient).apiUrl)
+
+	reporter, err = NewCommitStatusReporter(&Vcs{Url: "ssh://git@git.acme.com:7999/jfrog/project.git", CommitStatus: &CommitStatus{Provider: GitLabProvider}})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://git.acme.com/api/v4", reporter.client.(*gitLabStatusClient).apiUrl)
+
+	_, err = NewCommitStatusReporter(&Vcs{Url: "https://git.acme.com/jfrog/project", CommitStatus: &CommitStatus{}})
+	assert.Error(t, err)
+	_, err = NewCommitStatusReporter(&Vcs{Url: "https://github.com/jfrog/project", CommitStatus: &CommitStatus{Provider: "gitea"}})
+	assert.Error(t, err)
+	_, err = NewCommitStatusReporter(&Vcs{Url: "/tmp/project", CommitStatus: &CommitStatus{Provider: GitHubProvider}})
+	assert.Error(t, err)
+}
+
+func TestGetBuildUrl(t *testing.T) {
+	assert.Equal(t, "https://acme.jfrog.io/artifactory/webapp/builds/my%20build/3", GetBuildUrl("my build", "3", &JfrogDetails{ArtUrl: "https://acme.jfrog.io/artifactory/"}))
+}
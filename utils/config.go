@@ -2,6 +2,7 @@ package utils
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -20,10 +21,18 @@ const (
 
 // Define the file 'config.yaml'.
 type BuildConfig struct {
-	ProjectName  string        `yaml:"projectName"`
+	ProjectName string `yaml:"projectName"`
+	// Optional module name, for projects which are a part of a larger repository.
+	Module       string        `yaml:"module"`
 	BuildCommand string        `yaml:"buildCommand"`
 	Vcs          *Vcs          `yaml:"vcs"`
 	Jfrog        *JfrogDetails `yaml:"jfrog"`
+	Network      *Network      `yaml:"network"`
+	Retry        *RetryPolicy  `yaml:"retry"`
+	Metrics      *Metrics      `yaml:"metrics"`
+	Log          *Logging      `yaml:"log"`
+	// Notifications on violations found by Xray.
+	Notifications []Notification `yaml:"notifications"`
 }
 
 type JfrogDetails struct {
@@ -32,14 +41,73 @@ type JfrogDetails struct {
 	Password     string               `yaml:"password"`
 	Repositories map[BuildTool]string `yaml:"repositories"`
 	BuildName    string               `yaml:"buildName"`
+	// Build name template for tags, defaults to '${projectName}-${tag|sanitize}'.
+	TagBuildName string `yaml:"tagBuildName"`
+	// One of 'default', 'monotonic', 'timestamp', 'semver-from-tag' or 'template'. See NewBuildNumberScheme.
+	BuildNumberScheme string `yaml:"buildNumberScheme"`
+	// Build number template, used by the 'template' scheme.
+	BuildNumberTemplate string `yaml:"buildNumberTemplate"`
+	// The JFrog Project key. Builds are published and scanned in the project.
+	ProjectKey string `yaml:"projectKey"`
+	// The repository of the published build-infos. Defaults to '<projectKey>-build-info' with a project key, and to 'artifactory-build-info' otherwise.
+	BuildInfoRepo string `yaml:"buildInfoRepo"`
+	// Build retention policies of branches, applied after each publish.
+	Retention []Retention `yaml:"retention"`
+	// A generic repository to archive the build, publish and scan output of each commit in. Logs aren't archived when empty.
+	BuildLogsRepo string `yaml:"buildLogsRepo"`
+	// A generic repository to store the scan result of each commit, and the vulnerability timeline of each branch in. Not stored when empty.
+	ScanResultsRepo string `yaml:"scanResultsRepo"`
+	// Only fail the scan of a commit on violations which are new since the previous scanned commit of the branch.
+	// The scan results are compared if 'ScanResultsRepo' is set. Otherwise, all the violations fail the scan.
+	FailOnNewViolationsOnly bool `yaml:"failOnNewViolationsOnly"`
 }
 
 type Vcs struct {
-	Url      string   `yaml:"url"`
-	User     string   `yaml:"user"`
-	Password string   `yaml:"password"`
-	Token    string   `yaml:"token"`
+	// The repository URL. Credentials embedded in an http(s) URL are moved to 'User' and 'Password', unless they are set.
+	Url      string `yaml:"url"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+	// Branch names, glob patterns ('release/*') or regular expressions ('hotfix-.*') to scan.
+	// A regular expression without '.*', '+', '(', ')', '|', '{', '}', '^', '$' or '\' must be marked by a 're:' prefix or enclosing slashes, such as 're:hotfix-[0-9]'.
 	Branches []string `yaml:"branches"`
+	// Branch names or patterns to exclude from the matched branches.
+	ExcludeBranches []string `yaml:"excludeBranches"`
+	// Limit the clone to the given number of commits. The history is deepened on demand, up to 'MaxDepth' commits.
+	Depth int `yaml:"depth"`
+	// The maximum number of commits to deepen a shallow clone to, while searching the last scanned commit. 0 for no limit.
+	MaxDepth int `yaml:"maxDepth"`
+	// Clone only the branches matching 'Branches'.
+	SingleBranch bool `yaml:"singleBranch"`
+	// Don't fetch tags. Can't be used with 'Tags'.
+	NoTags bool `yaml:"noTags"`
+	// One of 'none', 'top-level' or 'recursive' (default). The submodules are updated on each checkout.
+	Submodules SubmodulePolicy `yaml:"submodules"`
+	// Credentials for submodules hosted on other servers. Submodules on the project server which don't match any use the project credentials.
+	SubmoduleCredentials []SubmoduleCredentials `yaml:"submoduleCredentials"`
+	// Download the content of Git LFS files for each checked out commit.
+	Lfs bool `yaml:"lfs"`
+	// The Git LFS server URL. Defaults to '<url>.git/info/lfs'.
+	LfsUrl string `yaml:"lfsUrl"`
+	// Remove the untracked and ignored files before building each commit, like 'git clean -ffdx', and verify the worktree matches the commit.
+	Clean bool `yaml:"clean"`
+	// Gitignore patterns of the untracked and ignored paths to keep when cleaning, such as dependency caches: 'node_modules/', '.gradle/'.
+	CleanExclude []string `yaml:"cleanExclude"`
+	// Keep the cloned project between runs and update it with an incremental fetch.
+	PersistentClone bool `yaml:"persistentClone"`
+	// One of 'go-git' (default) or 'git', which runs the git binary.
+	GitBackend string `yaml:"gitBackend"`
+	// Report the scan result of each commit to the VCS provider as a commit status. The provider API is authenticated with 'Token'.
+	CommitStatus *CommitStatus `yaml:"commitStatus"`
+	// Release tags to scan. Each tag is scanned once and published under 'JfrogDetails.TagBuildName'.
+	Tags *Tags `yaml:"tags"`
+}
+
+type Tags struct {
+	// Tag names, glob patterns or regular expressions to scan, as in 'Vcs.Branches'.
+	Patterns []string `yaml:"patterns"`
+	// A semantic versions range, such as '>=1.2.0 <2.0.0 || 3.0.0'.
+	Versions string `yaml:"versions"`
 }
 
 type BuildTool string
@@ -63,13 +131,39 @@ func LoadBuildConfig() (*BuildConfig, artifactory.ArtifactoryServicesManager, er
 	if err != nil {
 		return nil, nil, err
 	}
-	artifactoryServicesManager, err := createServiceManager(config)
+	if err = ConfigureLogging(config.Log); err != nil {
+		return nil, nil, err
+	}
+	if config.Vcs != nil {
+		if err = validateVcs(config.Vcs); err != nil {
+			return nil, nil, err
+		}
+		moveVcsUrlCredentials(config.Vcs)
+	}
+	ConfigureRetry(config.Retry)
+	certsDir, err := ConfigureNetwork(config.Network)
+	if err != nil {
+		return nil, nil, err
+	}
+	artifactoryServicesManager, err := createServiceManager(config, certsDir)
 	if err != nil {
 		return nil, nil, err
 	}
 	return config, artifactoryServicesManager, err
 }
 
+func validateVcs(vcs *Vcs) error {
+	if vcs.NoTags && vcs.Tags != nil {
+		return errors.New("'vcs.noTags' can't be used with 'vcs.tags', because the tags to scan must be fetched")
+	}
+	if vcs.Tags != nil {
+		if err := validateSemVersionRange(vcs.Tags.Versions); err != nil {
+			return fmt.Errorf("invalid 'vcs.tags.versions' range '%s': %s", vcs.Tags.Versions, err.Error())
+		}
+	}
+	return nil
+}
+
 func getConfig() ([]byte, error) {
 	// Load from env var.
 	if fromEnv := os.Getenv(configEnvVar); fromEnv != "" {
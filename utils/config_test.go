@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"encoding/base64"
 	"os"
 	"path/filepath"
 	"testing"
@@ -36,6 +37,30 @@ func TestLoadConfigByEnv(t *testing.T) {
 	runConfigValidation(t)
 }
 
+func TestLoadConfigNoTagsWithTags(t *testing.T) {
+	if fromEnv := os.Getenv(configEnvVar); fromEnv != "" {
+		defer func() { err := os.Setenv(configEnvVar, fromEnv); assert.NoError(t, err) }()
+	} else {
+		defer func() { assert.NoError(t, os.Unsetenv(configEnvVar)) }()
+	}
+	config := "vcs:\n  url: https://github.com/jfrog/project.git\n  noTags: true\n  tags:\n    patterns:\n    - v*\n"
+	assert.NoError(t, os.Setenv(configEnvVar, base64.StdEncoding.EncodeToString([]byte(config))))
+	_, _, err := LoadBuildConfig()
+	assert.EqualError(t, err, "'vcs.noTags' can't be used with 'vcs.tags', because the tags to scan must be fetched")
+}
+
+func TestLoadConfigInvalidVersionsRange(t *testing.T) {
+	if fromEnv := os.Getenv(configEnvVar); fromEnv != "" {
+		defer func() { err := os.Setenv(configEnvVar, fromEnv); assert.NoError(t, err) }()
+	} else {
+		defer func() { assert.NoError(t, os.Unsetenv(configEnvVar)) }()
+	}
+	config := "vcs:\n  url: https://github.com/jfrog/project.git\n  tags:\n    versions: '>=1.2.0 <2.0.0 || >=3.x'\n"
+	assert.NoError(t, os.Setenv(configEnvVar, base64.StdEncoding.EncodeToString([]byte(config))))
+	_, _, err := LoadBuildConfig()
+	assert.EqualError(t, err, "invalid 'vcs.tags.versions' range '>=1.2.0 <2.0.0 || >=3.x': '3.x' is not a semantic version")
+}
+
 func runConfigValidation(t *testing.T) {
 	buildConfig, ArtifactoryServicesManager, err := LoadBuildConfig()
 	assert.NoError(t, err)
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/jfrog/jfrog-client-go/artifactory"
+	"github.com/jfrog/jfrog-client-go/artifactory/buildinfo"
+	servicesutils "github.com/jfrog/jfrog-client-go/artifactory/services/utils"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+const (
+	// The property of the build-info files which holds the scanned commit revision, the scan cursor of the branch.
+	scanCursorProperty = "vcs-agent.revision"
+	// The maximum number of recently published revisions to consider as the scan cursor.
+	maxScanCursorCandidates = 100
+)
+
+// Store the scanned commit revision of a published build, as a property of its build-info file.
+func SetScanCursor(servicesManager artifactory.ArtifactoryServicesManager, buildName, buildNumber, revision string, jfrog *JfrogDetails) error {
+	return setBuildInfoProps(servicesManager, buildName, buildNumber, scanCursorProperty+"="+revision, jfrog)
+}
+
+// Returns the revision of the last scanned commit of buildName, from which the new commits are scanned.
+// The cursor is the latest scanned revision which is HEAD or an ancestor of HEAD, so that manual builds
+// and re-runs of older commits don't reset it. Builds which were published without a cursor fall back to
+// the revision of the latest build-info.
+func FindScanCursor(servicesManager artifactory.ArtifactoryServicesManager, buildName string, bi *buildinfo.BuildInfo, b GitBackend, buildConfig *BuildConfig) (string, error) {
+	log.Info("Searching the last scanned commit of '" + buildName + "'...")
+	var revisions []string
+	err := Retry("Searching the scanned revisions of '"+buildName+"'", func() (err error) {
+		revisions, err = getScannedRevisions(servicesManager, buildName, buildConfig.Jfrog)
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+	cursor, err := selectScanCursor(revisions, b)
+	if err != nil || cursor != "" {
+		return cursor, err
+	}
+	log.Info("No scanned revision of '" + buildName + "' is an ancestor of HEAD. Searching the latest commit revision in the build-info...")
+	return getBuildCommitSha(bi, buildConfig.Vcs.Url)
+}
+
+// Returns the new commits of the checked out branch to scan, and the build number of the latest build of buildName.
+// If no build was published under buildName, the branch is scanned for the first time, and only its HEAD commit is scanned.
+func GetBranchCommitsToScan(servicesManager artifactory.ArtifactoryServicesManager, buildName string, b GitBackend, buildConfig *BuildConfig) (prevBuildNumber string, commits []object.Commit, err error) {
+	bi, err := GetLatestBuildInfo(servicesManager, buildName, buildConfig.Jfrog)
+	if err != nil {
+		return "", nil, err
+	}
+	if bi == nil {
+		log.Info("No build of '" + buildName + "' was published. Scanning only the latest commit of the branch...")
+		r, err := b.Repository()
+		if err != nil {
+			return "", nil, err
+		}
+		head, err := r.Head()
+		if err != nil {
+			return "", nil, err
+		}
+		commit, err := r.CommitObject(head.Hash())
+		if err != nil {
+			return "", nil, err
+		}
+		return "", []object.Commit{*commit}, nil
+	}
+	cursor, err := FindScanCursor(servicesManager, buildName, bi, b, buildConfig)
+	if err != nil {
+		return "", nil, err
+	}
+	commits, err = GetCommitsToScan(cursor, b)
+	return bi.Number, commits, err
+}
+
+// Returns the newest revision in the history of HEAD out of 'revisions', or an empty string if none is in it.
+// A shallow clone is deepened only to find the latest published revision, which is usually the cursor.
+// The other revisions are compared only if they are in the local history, so a stale revision doesn't deepen the clone to its full history.
+func selectScanCursor(revisions []string, b GitBackend) (string, error) {
+	if len(revisions) == 0 {
+		return "", nil
+	}
+	if err := b.DeepenUntilFound(revisions[0]); err != nil {
+		return "", err
+	}
+	r, err := b.Repository()
+	if err != nil {
+		return "", err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return "", err
+	}
+	cursor := ""
+	for _, revision := range revisions {
+		if _, err = r.CommitObject(plumbing.NewHash(revision)); err != nil {
+			// A revision which is missing from the local history is not in the history of HEAD.
+			continue
+		}
+		if cursor != "" {
+			// Only a descendant of the current cursor is newer in the history.
+			newer, err := b.IsAncestor(cursor, revision)
+			if err != nil {
+				return "", err
+			}
+			if !newer {
+				continue
+			}
+		}
+		inHistory, err := b.IsAncestor(revision, head.Hash().String())
+		if err != nil {
+			return "", err
+		}
+		if inHistory {
+			cursor = revision
+		}
+	}
+	return cursor, nil
+}
+
+// Returns the distinct scan cursors of the builds of buildName, from the latest to the oldest published.
+func getScannedRevisions(servicesManager artifactory.ArtifactoryServicesManager, buildName string, jfrog *JfrogDetails) ([]string, error) {
+	find, err := json.Marshal(map[string]interface{}{
+		"repo":                   getBuildInfoRepo(jfrog),
+		"path":                   buildName,
+		"name":                   map[string]string{"$match": "*.json"},
+		"@" + scanCursorProperty: map[string]string{"$match": "*"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Artifactory doesn't support sorting queries which include properties, therefore the results are sorted here.
+	content, err := servicesManager.Aql(fmt.Sprintf(`items.find(%s).include("name","created","property")`, find))
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	result := new(servicesutils.AqlSearchResult)
+	if err = json.NewDecoder(content).Decode(result); err != nil {
+		return nil, err
+	}
+	type scannedRevision struct {
+		revision string
+		created  time.Time
+	}
+	var scanned []scannedRevision
+	for _, item := range result.Results {
+		created, err := time.Parse(time.RFC3339, item.Created)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected creation time of build-info file '%s': %s", item.Name, err.Error())
+		}
+		scanned = append(scanned, scannedRevision{item.GetProperty(scanCursorProperty), created})
+	}
+	sort.SliceStable(scanned, func(i, j int) bool {
+		return scanned[i].created.After(scanned[j].created)
+	})
+	var revisions []string
+	for _, s := range scanned {
+		if plumbing.IsHash(s.revision) && !containsString(revisions, s.revision) {
+			revisions = append(revisions, s.revision)
+		}
+		if len(revisions) == maxScanCursorCandidates {
+			break
+		}
+	}
+	return revisions, nil
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jfrog/jfrog-client-go/artifactory/buildinfo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindScanCursor(t *testing.T) {
+	remote, hashes := createRepoWithCommits(t, 4)
+	vcs := &Vcs{Url: getRepoPath(t, remote)}
+	buildConfig := &BuildConfig{Vcs: vcs, Jfrog: &JfrogDetails{}}
+	bi := &buildinfo.BuildInfo{VcsList: []buildinfo.Vcs{{Url: vcs.Url, Revision: hashes[1].String()}}}
+	for _, backend := range []string{GoGitBackend, CliGitBackend} {
+		t.Run(backend, func(t *testing.T) {
+			vcs.GitBackend = backend
+			b := cloneToTempDir(t, vcs)
+			assert.NoError(t, CheckoutBranch("master", b))
+
+			// The latest build is of a commit which is not in the history, and a re-run of an older commit followed the scan of hashes[2].
+			results := []string{
+				createAqlResult("5-1610000400000.json", "2021-01-07T06:20:00.000Z", strings.Repeat("a", 40)),
+				createAqlResult("4-1610000300000.json", "2021-01-07T06:18:20.000Z", hashes[0].String()),
+				createAqlResult("3-1610000200000.json", "2021-01-07T06:16:40.000+00:00", hashes[2].String()),
+				createAqlResult("1-1610000000000.json", "2021-01-07T06:13:20.000Z", hashes[0].String()),
+			}
+			server := createAqlServer(t, results)
+			cursor, err := FindScanCursor(createTestServicesManager(t, server), "my-build", bi, b, buildConfig)
+			assert.NoError(t, err)
+			assert.Equal(t, hashes[2].String(), cursor)
+
+			// Builds without a scan cursor fall back to the revision of the latest build-info.
+			server = createAqlServer(t, nil)
+			cursor, err = FindScanCursor(createTestServicesManager(t, server), "my-build", bi, b, buildConfig)
+			assert.NoError(t, err)
+			assert.Equal(t, hashes[1].String(), cursor)
+		})
+	}
+}
+
+func TestSelectScanCursorShallowClone(t *testing.T) {
+	remote, hashes := createRepoWithCommits(t, 6)
+	for _, backend := range []string{GoGitBackend, CliGitBackend} {
+		t.Run(backend, func(t *testing.T) {
+			// The git binary ignores the depth of local clones, unless the URL has the 'file://' scheme.
+			vcs := &Vcs{Url: "file://" + getRepoPath(t, remote), Depth: 1, NoTags: true, GitBackend: backend}
+			b := cloneToTempDir(t, vcs)
+			assert.NoError(t, CheckoutBranch("master", b))
+
+			// The clone is deepened to find the latest published revision, but not to find an older stale revision.
+			cursor, err := selectScanCursor([]string{hashes[4].String(), hashes[0].String(), strings.Repeat("a", 40)}, b)
+			assert.NoError(t, err)
+			assert.Equal(t, hashes[4].String(), cursor)
+			_, err = repository(t, b).CommitObject(hashes[0])
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGetBranchCommitsToScanFirstScan(t *testing.T) {
+	remote, hashes := createRepoWithCommits(t, 3)
+	vcs := &Vcs{Url: getRepoPath(t, remote)}
+	b := cloneToTempDir(t, vcs)
+	assert.NoError(t, CheckoutBranch("master", b))
+
+	// The branch build was never published, so only HEAD is scanned.
+	server := createAqlServer(t, nil)
+	prevBuildNumber, commits, err := GetBranchCommitsToScan(createTestServicesManager(t, server), "my-build", b, &BuildConfig{Vcs: vcs, Jfrog: &JfrogDetails{}})
+	assert.NoError(t, err)
+	assert.Empty(t, prevBuildNumber)
+	if assert.Len(t, commits, 1) {
+		assert.Equal(t, hashes[len(hashes)-1], commits[0].Hash)
+	}
+}
+
+func createAqlResult(name, created, revision string) string {
+	return fmt.Sprintf(`{"repo":"artifactory-build-info","path":"my-build","name":"%s","created":"%s","properties":[{"key":"%s","value":"%s"}]}`, name, created, scanCursorProperty, revision)
+}
+
+// Returns a server which responds to AQL queries with 'results'.
+func createAqlServer(t *testing.T, results []string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/search/aql" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Contains(t, string(query), `"@vcs-agent.revision":{"$match":"*"}`)
+		_, err = w.Write([]byte(`{"results":[` + strings.Join(results, ",") + `]}`))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
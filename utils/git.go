@@ -3,15 +3,19 @@ package utils
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
-	"github.com/jfrog/jfrog-client-go/artifactory/buildinfo"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/jfrog/jfrog-client-go/utils/io/fileutils"
 	"github.com/jfrog/jfrog-client-go/utils/log"
 )
@@ -21,85 +25,309 @@ const (
 	defaultRemote = "origin"
 )
 
-func CheckoutBranch(branch string, r *git.Repository) error {
-	log.Info("Checkout to '" + branch + "' branch")
-	w, err := r.Worktree()
+// Returns the remote branches matching 'vcs.Branches' and not matching 'vcs.ExcludeBranches'.
+// Branches are returned in the order of the patterns that matched them, and alphabetically for each pattern.
+func GetBranchesToScan(r *git.Repository, vcs *Vcs) ([]string, error) {
+	remoteBranches, err := getRemoteBranches(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return w.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.NewRemoteReferenceName(defaultRemote, branch),
-		Force:  true,
-	})
+	branches := selectBranches(remoteBranches, vcs)
+	log.Info(fmt.Sprintf("Found %d branches to scan: %s", len(branches), strings.Join(branches, ", ")))
+	return branches, nil
 }
 
-func CheckoutHash(hash string, r *git.Repository) error {
-	log.Info("Checkout to '" + hash + "' commmit")
-	w, err := r.Worktree()
+func selectBranches(remoteBranches []string, vcs *Vcs) []string {
+	var branches []string
+	added := make(map[string]bool)
+	for _, pattern := range vcs.Branches {
+		matched := false
+		for _, branch := range remoteBranches {
+			if !matchName(pattern, branch) {
+				continue
+			}
+			matched = true
+			if added[branch] || matchAnyName(vcs.ExcludeBranches, branch) {
+				continue
+			}
+			added[branch] = true
+			branches = append(branches, branch)
+		}
+		if !matched {
+			log.Warn("No remote branch matches '" + pattern + "'")
+		}
+	}
+	return branches
+}
+
+// Returns the sorted branch names of the default remote.
+func getRemoteBranches(r *git.Repository) ([]string, error) {
+	refs, err := r.References()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return w.Checkout(&git.CheckoutOptions{
-		Hash:  plumbing.NewHash(hash),
-		Force: true,
+	prefix := plumbing.NewRemoteReferenceName(defaultRemote, "").String()
+	var branches []string
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().String()
+		if ref.Name().IsRemote() && strings.HasPrefix(name, prefix) && name != prefix+"HEAD" {
+			branches = append(branches, strings.TrimPrefix(name, prefix))
+		}
+		return nil
 	})
+	sort.Strings(branches)
+	return branches, err
 }
 
-// Returns the commits bwtween fromSha - HEAD.
-// Due to 'Force push',the commit may be missing. As a result, the latest commit will be returned.
-func GetCommitsRange(fromSha string, r *git.Repository) (commits []object.Commit, err error) {
-	_, err = r.CommitObject(plumbing.NewHash(fromSha))
-	getLatestCommit := false
-	if err != nil {
-		log.Info("Commit sha: '" + fromSha + "' wasn't found in the commits log. This may be the result of force push command. As a result, scanning only the latest commit on this branch.")
-		getLatestCommit = true
+// Regular expression syntax which glob patterns don't have.
+var regexOnlySyntax = []string{".*", "+", "(", ")", "|", "{", "}", "^", "$", "\\"}
+
+// A branch or a tag matches a pattern if it is equal to it, or matches it as a glob.
+// A regular expression must be fully matched. A pattern is a regular expression if it's marked as one, as in 're:hotfix-[0-9]'
+// or '/hotfix-[0-9]/', or if it has syntax which globs don't have, as in 'hotfix-.*'. Otherwise, 'release/1.0' would match 'release/1x0'.
+func matchName(pattern, name string) bool {
+	if pattern == name {
+		return true
+	}
+	if expr, ok := toRegex(pattern); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		return err == nil && re.MatchString(name)
+	}
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// Returns the regular expression of pattern, and whether pattern is a regular expression.
+func toRegex(pattern string) (string, bool) {
+	if strings.HasPrefix(pattern, "re:") {
+		return strings.TrimPrefix(pattern, "re:"), true
+	}
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return pattern[1 : len(pattern)-1], true
+	}
+	for _, syntax := range regexOnlySyntax {
+		if strings.Contains(pattern, syntax) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+func matchAnyName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchName(pattern, name) {
+			return true
+		}
 	}
-	cIter, err := r.Log(&git.LogOptions{})
+	return false
+}
+
+// Returns the sorted tags matching the tags selector.
+// If the selector has patterns, a tag must match one of them. If it has a versions range, a tag must be a semantic version in that range.
+func GetTagsToScan(r *git.Repository, selector *Tags) ([]string, error) {
+	iter, err := r.Tags()
 	if err != nil {
-		return
+		return nil, err
 	}
-	// Iterates over the commits from top to buttom. Save the commit hash till 'fromSha' is found.
-	err = cIter.ForEach(func(c *object.Commit) error {
-		found := c.Hash.String() == fromSha
-		if !found {
-			commits = append([]object.Commit{*c}, commits...)
+	var tags []string
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		tag := ref.Name().Short()
+		if len(selector.Patterns) > 0 && !matchAnyName(selector.Patterns, tag) {
+			return nil
 		}
-		if found || getLatestCommit {
-			return storer.ErrStop
+		if selector.Versions != "" {
+			version, err := parseSemVersion(tag)
+			if err != nil {
+				return nil
+			}
+			matched, err := matchSemVersionRange(selector.Versions, version)
+			if err != nil || !matched {
+				return err
+			}
 		}
+		tags = append(tags, tag)
 		return nil
 	})
-	return
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tags)
+	log.Info(fmt.Sprintf("Found %d tags to scan: %s", len(tags), strings.Join(tags, ", ")))
+	return tags, nil
 }
 
-// Clone a vcs repository into the path.
-// If the path is not empty, ErrRepositoryAlreadyExists is returned.
-func Clone(path string, vcs *Vcs) (gitRepo *git.Repository, err error) {
-	cloneOption := &git.CloneOptions{
-		URL:  vcs.Url,
-		Auth: createCredentials(vcs),
-		// Enable git submodules clone.
-		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+// Returns the commit a tag is pointing to. Both lightweight and annotated tags are supported.
+func GetTagCommit(tag string, r *git.Repository) (*object.Commit, error) {
+	ref, err := r.Tag(tag)
+	if err != nil {
+		return nil, err
 	}
-	gitRepo, err = git.PlainClone(path, false, cloneOption)
-	return
+	tagObject, err := r.TagObject(ref.Hash())
+	switch err {
+	case nil:
+		return tagObject.Commit()
+	case plumbing.ErrObjectNotFound:
+		return r.CommitObject(ref.Hash())
+	}
+	return nil, err
 }
 
-func createCredentials(c *Vcs) (auth transport.AuthMethod) {
-	password := c.Token
-	if password == "" {
-		password = c.Password
+// Clone a vcs repository into the backend path, and update its submodules according to the submodules policy.
+// The path must be empty.
+// If 'vcs.SingleBranch' is set, only the branches matching 'vcs.Branches' are cloned.
+func Clone(b GitBackend, vcs *Vcs) error {
+	// A failed clone removes the files it created, so it can be retried into the same path.
+	if err := Retry("Cloning '"+vcs.Url+"'", b.Clone); err != nil {
+		return err
 	}
-	return &http.BasicAuth{Username: c.User, Password: password}
+	r, err := b.Repository()
+	if err != nil {
+		return err
+	}
+	return UpdateSubmodules(r, vcs)
 }
 
-func GetCommitsToScan(bi *buildinfo.BuildInfo, r *git.Repository, vcsUrl string) ([]object.Commit, error) {
-	log.Info("Searching the latest commit revision in the build-info...")
-	sha, err := getBuildCommitSha(bi, vcsUrl)
+// Open the project previously cloned into the path and fetch the latest changes.
+// Remote branches and tags which were deleted from the remote are pruned.
+// If the path doesn't contain a valid clone of the vcs repository, the project is cloned again.
+// A failed fetch is returned, and the clone is kept for the next run.
+func OpenOrClone(path string, vcs *Vcs, b GitBackend) error {
+	_, err := openClone(path, vcs)
+	if err == nil {
+		log.Info("Fetching the latest changes into '" + path + "'")
+		return Retry("Fetching '"+vcs.Url+"'", b.Fetch)
+	}
+	if err != git.ErrRepositoryNotExists {
+		log.Warn("The project at '" + path + "' can't be updated, cloning it again. Error: " + err.Error())
+	}
+	if err = recreateDir(path); err != nil {
+		return err
+	}
+	log.Info("Cloning project '" + vcs.Url + "' to '" + path + "'")
+	return Clone(b, vcs)
+}
+
+// Returns the remote branches matching 'vcs.Branches', without cloning the repository.
+func listBranchesToClone(vcs *Vcs) ([]string, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: defaultRemote, URLs: []string{vcs.Url}})
+	refs, err := remote.List(&git.ListOptions{Auth: createCredentials(vcs)})
+	if err != nil {
+		return nil, err
+	}
+	var remoteBranches []string
+	for _, ref := range refs {
+		if ref.Name().IsBranch() {
+			remoteBranches = append(remoteBranches, ref.Name().Short())
+		}
+	}
+	sort.Strings(remoteBranches)
+	branches := selectBranches(remoteBranches, vcs)
+	if len(branches) == 0 {
+		return nil, fmt.Errorf("no remote branch of '%s' matches %v", vcs.Url, vcs.Branches)
+	}
+	return branches, nil
+}
+
+// Open an existing clone and verify it is a healthy clone of the vcs repository.
+func openClone(path string, vcs *Vcs) (*git.Repository, error) {
+	gitRepo, err := git.PlainOpen(path)
 	if err != nil {
 		return nil, err
 	}
-	commits, err := GetCommitsRange(sha, r)
+	remote, err := gitRepo.Remote(defaultRemote)
+	if err != nil {
+		return nil, err
+	}
+	if urls := remote.Config().URLs; len(urls) == 0 || urls[0] != vcs.Url {
+		return nil, fmt.Errorf("the project was cloned from a different repository: %v", urls)
+	}
+	head, err := gitRepo.Head()
+	if err != nil {
+		return nil, err
+	}
+	// The objects of a corrupted clone may be missing.
+	if _, err = gitRepo.CommitObject(head.Hash()); err != nil {
+		return nil, err
+	}
+	return gitRepo, nil
+}
+
+// Delete the local remote branches and tags which don't exist in 'remoteRefs'.
+func pruneReferences(gitRepo *git.Repository, remoteRefs []*plumbing.Reference) error {
+	exists := make(map[plumbing.ReferenceName]bool)
+	for _, ref := range remoteRefs {
+		name := ref.Name()
+		if name.IsBranch() {
+			name = plumbing.NewRemoteReferenceName(defaultRemote, name.Short())
+		}
+		exists[name] = true
+	}
+	refs, err := gitRepo.References()
+	if err != nil {
+		return err
+	}
+	remotePrefix := plumbing.NewRemoteReferenceName(defaultRemote, "").String()
+	var toDelete []plumbing.ReferenceName
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name()
+		isRemoteBranch := strings.HasPrefix(name.String(), remotePrefix) && name.String() != remotePrefix+"HEAD"
+		if (isRemoteBranch || name.IsTag()) && !exists[name] {
+			toDelete = append(toDelete, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range toDelete {
+		log.Info("Pruning '" + name.String() + "', which was deleted from the remote")
+		if err = gitRepo.Storer.RemoveReference(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func CheckoutBranch(branch string, b GitBackend) error {
+	log.Info("Checkout to '" + branch + "' branch")
+	return b.Checkout(plumbing.NewRemoteReferenceName(defaultRemote, branch).String())
+}
+
+func CheckoutHash(hash string, b GitBackend) error {
+	SetLogStage(CheckoutStage)
+	log.Info("Checkout to '" + hash + "' commmit")
+	return b.Checkout(hash)
+}
+
+// Returns the author of a commit, as 'name <email>'.
+func GetCommitAuthor(hash string, gitRepo *git.Repository) (string, error) {
+	commit, err := gitRepo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return "", err
+	}
+	return commit.Author.Name + " <" + commit.Author.Email + ">", nil
+}
+
+func createCredentials(c *Vcs) (auth transport.AuthMethod) {
+	user, password := getCredentials(c)
+	return &http.BasicAuth{Username: user, Password: password}
+}
+
+// Returns the vcs user and password. The token is preferred over the password.
+func getCredentials(c *Vcs) (user, password string) {
+	return c.User, tokenOrPassword(c.Token, c.Password)
+}
+
+func tokenOrPassword(token, password string) string {
+	if token != "" {
+		return token
+	}
+	return password
+}
+
+// Returns the new commits since the scan cursor, the last scanned commit. See FindScanCursor.
+func GetCommitsToScan(cursor string, b GitBackend) ([]object.Commit, error) {
+	commits, err := GetCommitsRange(cursor, b)
 	if commits == nil {
 		log.Info("No new commits since the last run. Skipping... ")
 	} else {
@@ -108,6 +336,13 @@ func GetCommitsToScan(bi *buildinfo.BuildInfo, r *git.Repository, vcsUrl string)
 	return commits, err
 }
 
+// Returns the commits bwtween fromSha - HEAD.
+// Due to 'Force push',the commit may be missing. As a result, the latest commit will be returned.
+// The history of a shallow clone is deepened on demand, while searching fromSha.
+func GetCommitsRange(fromSha string, b GitBackend) ([]object.Commit, error) {
+	return b.LogRange(fromSha)
+}
+
 func ToShortCommitHash(hash string) string {
 	return hash[:8]
 }
@@ -116,22 +351,33 @@ func ToShortCommitHash(hash string) string {
 // Default path is at /agent_home/project/.
 // Override if exist.
 func CreateCloneDir() (string, error) {
-	// Create clone dir.
+	path, err := GetCloneDir()
+	if err != nil {
+		return "", err
+	}
+	return path, recreateDir(path)
+}
+
+// Returns the path of the project directory, at /agent_home/project/.
+func GetCloneDir() (string, error) {
 	wd, err := os.Getwd()
 	if err != nil {
 		return "", err
 	}
-	path := filepath.Join(wd, "project")
+	return filepath.Join(wd, "project"), nil
+}
+
+// Create an empty directory at path. Remove its content if exists.
+func recreateDir(path string) error {
 	exists, err := fileutils.IsDirExists(path, false)
 	if err != nil {
-		return "", err
+		return err
 	}
 	if exists {
 		err = os.RemoveAll(path)
 		if err != nil {
-			return "", err
+			return err
 		}
 	}
-	err = os.Mkdir(path, 0755)
-	return path, err
+	return os.Mkdir(path, 0755)
 }
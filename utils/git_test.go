@@ -1,11 +1,13 @@
 package utils
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/jfrog/jfrog-client-go/utils/io/fileutils"
 	"github.com/stretchr/testify/assert"
@@ -18,7 +20,7 @@ func TestCheckoutBranch(t *testing.T) {
 	r, err := git.PlainOpen(path)
 	assert.NoError(t, err)
 
-	err = CheckoutBranch("dev", r)
+	err = CheckoutBranch("dev", &goGitBackend{path: path, repo: r})
 	assert.NoError(t, err)
 	cIter, err := r.Log(&git.LogOptions{})
 	err = cIter.ForEach(func(c *object.Commit) error {
@@ -26,10 +28,306 @@ func TestCheckoutBranch(t *testing.T) {
 		return nil
 	})
 }
-func setupTmpDir(t *testing.T, dir string) (string, func()) {
+func TestGetBranchesToScan(t *testing.T) {
+	r := createRepoWithRemoteBranches(t, "main", "dev", "release/1.0", "release/2.0", "hotfix-1", "hotfix-2", "feature/foo")
+	vcs := &Vcs{
+		Branches:        []string{"main", "release/*", "hotfix-.*", "main", "missing"},
+		ExcludeBranches: []string{"hotfix-2"},
+	}
+	branches, err := GetBranchesToScan(r, vcs)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"main", "release/1.0", "release/2.0", "hotfix-1"}, branches)
+}
+
+func TestMatchName(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"release/1.0", "release/1.0", true},
+		{"release/1.0", "release/1x0", false},
+		{"release/1.*", "release/1x0", true},
+		{"release/*", "release/1.0", true},
+		{"release/?.0", "release/1.0", true},
+		{"hotfix-.*", "hotfix-1", true},
+		{"hotfix-.*", "feature/hotfix-1", false},
+		{"v[0-9]", "v1", true},
+		{"re:v[0-9]", "v1", true},
+		{"re:v[0-9]", "v10", false},
+		{"/v[0-9]+/", "v10", true},
+		{"(main|dev)", "dev", true},
+		{"feature/foo", "feature/foo/bar", false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, matchName(test.pattern, test.name), test.pattern+" "+test.name)
+	}
+}
+
+func TestGetTagsToScan(t *testing.T) {
+	r, hash := createRepoWithTags(t, "v1.0.0", "v1.5.0", "v2.0.0", "nightly")
+	tags, err := GetTagsToScan(r, &Tags{Versions: ">=1.2.0 <2.0.0 || 2.0.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v1.5.0", "v2.0.0"}, tags)
+
+	tags, err = GetTagsToScan(r, &Tags{Patterns: []string{"v1.*", "nightly"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nightly", "v1.0.0", "v1.5.0"}, tags)
+
+	tags, err = GetTagsToScan(r, &Tags{})
+	assert.NoError(t, err)
+	assert.Len(t, tags, 4)
+
+	commit, err := GetTagCommit("v1.5.0", r)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, commit.Hash)
+}
+
+func TestOpenOrClone(t *testing.T) {
+	for _, backend := range []string{GoGitBackend, CliGitBackend} {
+		t.Run(backend, func(t *testing.T) {
+			testOpenOrClone(t, backend)
+		})
+	}
+}
+
+func testOpenOrClone(t *testing.T, backend string) {
+	remote, _ := createRepoWithTags(t, "v1.0.0")
+	remoteWorktree, err := remote.Worktree()
+	assert.NoError(t, err)
+	assert.NoError(t, remoteWorktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("dev"), Create: true}))
+	vcs := &Vcs{Url: remoteWorktree.Filesystem.Root(), GitBackend: backend}
+	cloneDir, cleanup := createTempDir(t)
+	defer cleanup()
+	b, err := NewGitBackend(cloneDir, vcs)
+	assert.NoError(t, err)
+
+	// Clone into an empty path.
+	assert.NoError(t, OpenOrClone(cloneDir, vcs, b))
+	assertRemoteBranches(t, repository(t, b), "dev", "master")
+
+	// Fetch a new commit and prune a deleted branch and tag.
+	hash, err := remoteWorktree.Commit("Second commit", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@jfrog.com"}})
+	assert.NoError(t, err)
+	assert.NoError(t, remote.Storer.RemoveReference(plumbing.NewBranchReferenceName("master")))
+	assert.NoError(t, remote.DeleteTag("v1.0.0"))
+	assert.NoError(t, OpenOrClone(cloneDir, vcs, b))
+	r := repository(t, b)
+	assertRemoteBranches(t, r, "dev")
+	ref, err := r.Reference(plumbing.NewRemoteReferenceName(defaultRemote, "dev"), true)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+	_, err = r.Tag("v1.0.0")
+	assert.Equal(t, git.ErrTagNotFound, err)
+
+	// A failed fetch is returned, and the clone is kept.
+	setupRetryTest(t, &RetryPolicy{MaxAttempts: 1})
+	unavailable := vcs.Url + "-unavailable"
+	assert.NoError(t, os.Rename(vcs.Url, unavailable))
+	assert.Error(t, OpenOrClone(cloneDir, vcs, b))
+	assert.NoError(t, os.Rename(unavailable, vcs.Url))
+	assertRemoteBranches(t, repository(t, b), "dev")
+
+	// Clone again a corrupted project.
+	assert.NoError(t, os.Remove(filepath.Join(cloneDir, ".git", "HEAD")))
+	assert.NoError(t, OpenOrClone(cloneDir, vcs, &goGitBackend{path: cloneDir, vcs: vcs}))
+	assertRemoteBranches(t, repository(t, b), "dev")
+
+	// Clone again a project with missing objects.
+	objects := filepath.Join(cloneDir, ".git", "objects")
+	assert.NoError(t, os.RemoveAll(objects))
+	assert.NoError(t, os.Mkdir(objects, 0755))
+	assert.NoError(t, OpenOrClone(cloneDir, vcs, b))
+	_, err = repository(t, b).CommitObject(hash)
+	assert.NoError(t, err)
+}
+
+func TestShallowClone(t *testing.T) {
+	for _, backend := range []string{GoGitBackend, CliGitBackend} {
+		t.Run(backend, func(t *testing.T) {
+			testShallowClone(t, backend)
+		})
+	}
+}
+
+func testShallowClone(t *testing.T, backend string) {
+	remote, hashes := createRepoWithCommits(t, 6)
+	// The git binary ignores the depth of local clones, unless the URL has the 'file://' scheme.
+	vcs := &Vcs{Url: "file://" + getRepoPath(t, remote), Depth: 1, NoTags: true, GitBackend: backend}
+
+	// Deepen the history until the last scanned commit is found.
+	b := cloneToTempDir(t, vcs)
+	commits, err := GetCommitsToScan(hashes[1].String(), b)
+	assert.NoError(t, err)
+	assert.Len(t, commits, 4)
+	assert.Equal(t, hashes[2], commits[0].Hash)
+
+	// The last scanned commit is beyond the max depth. Only the latest commit is scanned.
+	vcs.MaxDepth = 2
+	b = cloneToTempDir(t, vcs)
+	commits, err = GetCommitsToScan(hashes[1].String(), b)
+	assert.NoError(t, err)
+	assert.Len(t, commits, 1)
+	assert.Equal(t, hashes[5], commits[0].Hash)
+}
+
+func TestSingleBranchClone(t *testing.T) {
+	for _, backend := range []string{GoGitBackend, CliGitBackend} {
+		t.Run(backend, func(t *testing.T) {
+			testSingleBranchClone(t, backend)
+		})
+	}
+}
+
+func testSingleBranchClone(t *testing.T, backend string) {
+	remote, _ := createRepoWithCommits(t, 1)
+	head, err := remote.Head()
+	assert.NoError(t, err)
+	for _, branch := range []string{"dev", "release/1.0", "feature/foo"} {
+		assert.NoError(t, remote.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), head.Hash())))
+	}
+	vcs := &Vcs{Url: getRepoPath(t, remote), SingleBranch: true, Branches: []string{"release/*", "dev"}, GitBackend: backend}
+	b := cloneToTempDir(t, vcs)
+	assertRemoteBranches(t, repository(t, b), "dev", "release/1.0")
+}
+
+func TestGitBackends(t *testing.T) {
+	remote, remotePath := createRepoWithFiles(t, map[string]string{"a.txt": "1"})
+	head, err := remote.Head()
+	assert.NoError(t, err)
+	hashes := []plumbing.Hash{head.Hash(), commitFile(t, remote, remotePath, "b.txt", "1"), commitFile(t, remote, remotePath, "a.txt", "2")}
+	for _, backend := range []string{GoGitBackend, CliGitBackend} {
+		t.Run(backend, func(t *testing.T) {
+			b := cloneToTempDir(t, &Vcs{Url: remotePath, GitBackend: backend})
+			assert.NoError(t, CheckoutBranch("master", b))
+			commits, err := GetCommitsRange(hashes[0].String(), b)
+			assert.NoError(t, err)
+			if assert.Len(t, commits, 2) {
+				assert.Equal(t, hashes[1], commits[0].Hash)
+				assert.Equal(t, hashes[2], commits[1].Hash)
+				assert.Equal(t, []plumbing.Hash{hashes[1]}, commits[1].ParentHashes)
+				assert.Equal(t, "test", commits[1].Author.Name)
+			}
+
+			assert.NoError(t, CheckoutHash(hashes[1].String(), b))
+			head, err := repository(t, b).Head()
+			assert.NoError(t, err)
+			assert.Equal(t, hashes[1], head.Hash())
+
+			// A missing commit returns only HEAD.
+			commits, err = GetCommitsRange(plumbing.ZeroHash.String(), b)
+			assert.NoError(t, err)
+			if assert.Len(t, commits, 1) {
+				assert.Equal(t, hashes[1], commits[0].Hash)
+			}
+
+			isAncestor, err := b.IsAncestor(hashes[0].String(), hashes[1].String())
+			assert.NoError(t, err)
+			assert.True(t, isAncestor)
+			isAncestor, err = b.IsAncestor(hashes[2].String(), hashes[1].String())
+			assert.NoError(t, err)
+			assert.False(t, isAncestor)
+			isAncestor, err = b.IsAncestor(plumbing.ZeroHash.String(), hashes[1].String())
+			assert.NoError(t, err)
+			assert.False(t, isAncestor)
+
+			files, err := b.Diff(hashes[0].String(), hashes[2].String())
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"a.txt", "b.txt"}, files)
+		})
+	}
+	_, err = NewGitBackend("", &Vcs{GitBackend: "svn"})
+	assert.Error(t, err)
+}
+
+func cloneToTempDir(t *testing.T, vcs *Vcs) GitBackend {
+	cloneDir, cleanup := createTempDir(t)
+	t.Cleanup(cleanup)
+	b, err := NewGitBackend(cloneDir, vcs)
+	assert.NoError(t, err)
+	assert.NoError(t, Clone(b, vcs))
+	return b
+}
+
+func repository(t *testing.T, b GitBackend) *git.Repository {
+	r, err := b.Repository()
+	assert.NoError(t, err)
+	return r
+}
+
+func getRepoPath(t *testing.T, r *git.Repository) string {
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+	return w.Filesystem.Root()
+}
+
+func assertRemoteBranches(t *testing.T, r *git.Repository, expected ...string) {
+	branches, err := getRemoteBranches(r)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, branches)
+}
+
+func createTempDir(t *testing.T) (string, func()) {
 	tmpDir, err := fileutils.CreateTempDir()
 	assert.NoError(t, err)
-	cleanup := func() { assert.NoError(t, os.RemoveAll(tmpDir)) }
+	return tmpDir, func() { assert.NoError(t, os.RemoveAll(tmpDir)) }
+}
+
+// Create a repository with the given number of commits on the master branch.
+// Returns the repository and the commits hashes, from the oldest to the newest.
+func createRepoWithCommits(t *testing.T, count int) (*git.Repository, []plumbing.Hash) {
+	tmpDir, cleanup := createTempDir(t)
+	t.Cleanup(cleanup)
+	r, err := git.PlainInit(tmpDir, false)
+	assert.NoError(t, err)
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+	var hashes []plumbing.Hash
+	for i := 0; i < count; i++ {
+		hash, err := w.Commit(fmt.Sprintf("Commit %d", i), &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@jfrog.com"}})
+		assert.NoError(t, err)
+		hashes = append(hashes, hash)
+	}
+	return r, hashes
+}
+
+// Create a repository with a single commit, tagged by the given tags. Every other tag is annotated.
+func createRepoWithTags(t *testing.T, tags ...string) (*git.Repository, plumbing.Hash) {
+	tmpDir, cleanup := createTempDir(t)
+	t.Cleanup(cleanup)
+	r, err := git.PlainInit(tmpDir, false)
+	assert.NoError(t, err)
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+	signature := &object.Signature{Name: "test", Email: "test@jfrog.com"}
+	hash, err := w.Commit("Initial commit", &git.CommitOptions{Author: signature})
+	assert.NoError(t, err)
+	for i, tag := range tags {
+		var opts *git.CreateTagOptions
+		if i%2 == 1 {
+			opts = &git.CreateTagOptions{Tagger: signature, Message: tag}
+		}
+		_, err = r.CreateTag(tag, hash, opts)
+		assert.NoError(t, err)
+	}
+	return r, hash
+}
+
+// Create a repository with remote branches of the default remote.
+func createRepoWithRemoteBranches(t *testing.T, branches ...string) *git.Repository {
+	tmpDir, cleanup := createTempDir(t)
+	t.Cleanup(cleanup)
+	r, err := git.PlainInit(tmpDir, false)
+	assert.NoError(t, err)
+	hash := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	for _, branch := range append(branches, "HEAD") {
+		assert.NoError(t, r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewRemoteReferenceName(defaultRemote, branch), hash)))
+	}
+	return r
+}
+
+func setupTmpDir(t *testing.T, dir string) (string, func()) {
+	tmpDir, cleanup := createTempDir(t)
 	testDataDir, err := filepath.Abs(filepath.Join("testdata", "git", dir))
 	assert.NoError(t, err)
 	assert.NoError(t, fileutils.CopyDir(testDataDir, tmpDir, true, nil))
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	// Pure Go git implementation. Doesn't require a git binary.
+	GoGitBackend = "go-git"
+	// Runs the 'git' binary. Faster on large repositories.
+	CliGitBackend = "git"
+)
+
+// The git operations the agent runs on the cloned project.
+type GitBackend interface {
+	// Clone the vcs repository into the backend path.
+	Clone() error
+	// Fetch the latest changes of the cloned branches and tags, and prune the ones which were deleted from the remote.
+	Fetch() error
+	// Force checkout a commit hash or a full reference name, such as 'refs/remotes/origin/master'.
+	Checkout(ref string) error
+	// Returns the commits between fromSha (exclusive) and HEAD, from the oldest to the newest.
+	// If fromSha is missing, only the HEAD commit is returned.
+	LogRange(fromSha string) ([]object.Commit, error)
+	// Returns true if sha is descendantSha or one of its ancestors. The history isn't deepened,
+	// therefore a commit which is missing from the local history is not an ancestor.
+	IsAncestor(sha, descendantSha string) (bool, error)
+	// Deepen the history of a shallow clone, until the commit sha is found or the depth reaches 'vcs.MaxDepth'.
+	DeepenUntilFound(sha string) error
+	// Returns the paths of the files which differ between two commits.
+	Diff(fromSha, toSha string) ([]string, error)
+	// Remove the untracked and ignored files and directories of the worktree, like 'git clean -ffdx'.
+	// Paths matching the gitignore patterns 'excludes' are kept. Submodules aren't cleaned.
+	Clean(excludes []string) error
+	// Returns the paths which differ between the worktree and HEAD: changed tracked files, and untracked or ignored files
+	// which don't match the gitignore patterns 'excludes'. Submodules aren't compared.
+	DirtyPaths(excludes []string) ([]string, error)
+	// Returns the go-git repository of the clone, for reading references and objects.
+	Repository() (*git.Repository, error)
+}
+
+// Returns the git backend selected by 'vcs.GitBackend', operating on the project at path.
+func NewGitBackend(path string, vcs *Vcs) (GitBackend, error) {
+	switch vcs.GitBackend {
+	case "", GoGitBackend:
+		return &goGitBackend{path: path, vcs: vcs}, nil
+	case CliGitBackend:
+		return newCliGitBackend(path, vcs)
+	}
+	return nil, fmt.Errorf("unknown git backend '%s', expecting one of: %s, %s", vcs.GitBackend, GoGitBackend, CliGitBackend)
+}
@@ -0,0 +1,290 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+const (
+	// The 'git log' format of a commit. Fields are separated by the unit separator and commits by the record separator.
+	gitLogFormat = "%H%x1f%T%x1f%P%x1f%an%x1f%ae%x1f%aI%x1f%cn%x1f%ce%x1f%cI%x1f%B%x1e"
+	gitLogFields = 10
+)
+
+// A git backend running the git binary.
+// The vcs credentials are passed to git as an HTTP header through the environment, so they aren't written to the clone config.
+type cliGitBackend struct {
+	path string
+	vcs  *Vcs
+	// Environment variables added to each git command.
+	env []string
+}
+
+func newCliGitBackend(path string, vcs *Vcs) (*cliGitBackend, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("the '%s' git backend requires git to be installed: %s", CliGitBackend, err.Error())
+	}
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if user, password := getCredentials(vcs); user != "" || password != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+		env = append(env, "GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=http.extraHeader", "GIT_CONFIG_VALUE_0=Authorization: Basic "+auth)
+	}
+	return &cliGitBackend{path: path, vcs: vcs, env: env}, nil
+}
+
+// The go-git repository is opened on each call, since git may have changed the clone since the last call.
+func (b *cliGitBackend) Repository() (*git.Repository, error) {
+	return git.PlainOpen(b.path)
+}
+
+func (b *cliGitBackend) Clone() error {
+	args := []string{"clone"}
+	if b.vcs.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(b.vcs.Depth))
+	}
+	if b.vcs.NoTags {
+		args = append(args, "--no-tags")
+	}
+	var branches []string
+	if b.vcs.SingleBranch {
+		var err error
+		if branches, err = listBranchesToClone(b.vcs); err != nil {
+			return err
+		}
+		args = append(args, "--single-branch", "--branch", branches[0])
+	}
+	if _, err := b.run(append(args, "--", b.vcs.Url, ".")...); err != nil {
+		return err
+	}
+	if len(branches) > 1 {
+		return b.fetch(b.vcs.Depth, branchRefSpecs(branches[1:]))
+	}
+	return nil
+}
+
+func (b *cliGitBackend) Fetch() error {
+	refSpecs, err := b.getRefSpecs()
+	if err != nil {
+		return err
+	}
+	return b.fetch(b.vcs.Depth, refSpecs)
+}
+
+// Returns the refspecs of the cloned branches. If 'vcs.SingleBranch' is set, these are the branches matching 'vcs.Branches'.
+func (b *cliGitBackend) getRefSpecs() ([]string, error) {
+	if !b.vcs.SingleBranch {
+		return []string{fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", defaultRemote)}, nil
+	}
+	branches, err := listBranchesToClone(b.vcs)
+	if err != nil {
+		return nil, err
+	}
+	return branchRefSpecs(branches), nil
+}
+
+// Fetch the refspecs from the default remote, and prune the branches and tags which were deleted from it.
+// A depth of 0 fetches the full history.
+func (b *cliGitBackend) fetch(depth int, refSpecs []string) error {
+	args := []string{"fetch", "--force", "--prune"}
+	if depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
+	}
+	if b.vcs.NoTags {
+		args = append(args, "--no-tags")
+	} else {
+		// '--prune-tags' is ignored when refspecs are given, therefore the tags refspec is added explicitly.
+		refSpecs = append(refSpecs, "+refs/tags/*:refs/tags/*")
+	}
+	_, err := b.run(append(append(args, defaultRemote), refSpecs...)...)
+	return err
+}
+
+func branchRefSpecs(branches []string) []string {
+	var refSpecs []string
+	for _, branch := range branches {
+		refSpecs = append(refSpecs, fmt.Sprintf("+%s:%s", plumbing.NewBranchReferenceName(branch), plumbing.NewRemoteReferenceName(defaultRemote, branch)))
+	}
+	return refSpecs
+}
+
+func (b *cliGitBackend) Checkout(ref string) error {
+	_, err := b.run("checkout", "--force", "--detach", ref)
+	return err
+}
+
+func (b *cliGitBackend) LogRange(fromSha string) ([]object.Commit, error) {
+	if err := b.DeepenUntilFound(fromSha); err != nil {
+		return nil, err
+	}
+	args := []string{"log", "--format=" + gitLogFormat}
+	if b.hasCommit(fromSha) {
+		args = append(args, "--reverse", fromSha+"..HEAD")
+	} else {
+		log.Info("Commit sha: '" + fromSha + "' wasn't found in the commits log. This may be the result of force push command. As a result, scanning only the latest commit on this branch.")
+		args = append(args, "-1", "HEAD")
+	}
+	out, err := b.run(args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseGitLog(out)
+}
+
+func (b *cliGitBackend) IsAncestor(sha, descendantSha string) (bool, error) {
+	if !b.hasCommit(sha) {
+		return false, nil
+	}
+	_, err := b.run("merge-base", "--is-ancestor", sha, descendantSha)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// Exit code 1 means that sha is not an ancestor.
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *cliGitBackend) Diff(fromSha, toSha string) ([]string, error) {
+	out, err := b.run("diff", "--name-only", "--no-renames", "-z", fromSha, toSha)
+	if err != nil || out == "" {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(out, "\x00"), "\x00"), nil
+}
+
+func (b *cliGitBackend) Clean(excludes []string) error {
+	args := []string{"clean", "-ffdxq"}
+	for _, exclude := range excludes {
+		args = append(args, "-e", exclude)
+	}
+	_, err := b.run(args...)
+	return err
+}
+
+func (b *cliGitBackend) DirtyPaths(excludes []string) ([]string, error) {
+	out, err := b.run("status", "--porcelain", "-z", "--ignored", "--untracked-files=all", "--ignore-submodules=all")
+	if err != nil || out == "" {
+		return nil, err
+	}
+	matcher := newExcludeMatcher(excludes)
+	var dirty []string
+	entries := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	for i := 0; i < len(entries); i++ {
+		// Each entry is 'XY path'. '??' marks untracked paths and '!!' ignored paths.
+		status, path := entries[i][:2], entries[i][3:]
+		if status[0] == 'R' || status[0] == 'C' {
+			// Skip the original path of a rename or a copy.
+			i++
+		}
+		if (status == "??" || status == "!!") && matcher.Match(strings.Split(strings.TrimSuffix(path, "/"), "/"), strings.HasSuffix(path, "/")) {
+			continue
+		}
+		dirty = append(dirty, path)
+	}
+	return dirty, nil
+}
+
+// The depth is doubled on each iteration.
+func (b *cliGitBackend) DeepenUntilFound(sha string) error {
+	depth := b.vcs.Depth
+	if depth < 1 {
+		depth = 1
+	}
+	for !b.hasCommit(sha) {
+		out, err := b.run("rev-parse", "--is-shallow-repository")
+		if err != nil || strings.TrimSpace(out) != "true" {
+			// The full history is available.
+			return err
+		}
+		if b.vcs.MaxDepth > 0 && depth >= b.vcs.MaxDepth {
+			log.Info(fmt.Sprintf("Commit '%s' wasn't found in the last %d commits.", sha, b.vcs.MaxDepth))
+			return nil
+		}
+		depth *= 2
+		if b.vcs.MaxDepth > 0 && depth > b.vcs.MaxDepth {
+			depth = b.vcs.MaxDepth
+		}
+		log.Info(fmt.Sprintf("Commit '%s' is not in the shallow history. Deepening the history to %d commits...", sha, depth))
+		refSpecs, err := b.getRefSpecs()
+		if err != nil {
+			return err
+		}
+		if err = b.fetch(depth, refSpecs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *cliGitBackend) hasCommit(sha string) bool {
+	if !plumbing.IsHash(sha) {
+		return false
+	}
+	_, err := b.run("cat-file", "-e", sha+"^{commit}")
+	return err == nil
+}
+
+// Run git in the project directory. Returns the standard output.
+func (b *cliGitBackend) run(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = b.path
+	cmd.Env = append(os.Environ(), b.env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// Parse the output of 'git log' with the 'gitLogFormat' format.
+func parseGitLog(out string) ([]object.Commit, error) {
+	var commits []object.Commit
+	for _, record := range strings.Split(out, "\x1e") {
+		record = strings.TrimLeft(record, "\n")
+		if record == "" {
+			continue
+		}
+		fields := strings.Split(record, "\x1f")
+		if len(fields) != gitLogFields {
+			return nil, fmt.Errorf("unexpected git log record: %q", record)
+		}
+		author, err := newSignature(fields[3], fields[4], fields[5])
+		if err != nil {
+			return nil, err
+		}
+		committer, err := newSignature(fields[6], fields[7], fields[8])
+		if err != nil {
+			return nil, err
+		}
+		commit := object.Commit{
+			Hash:      plumbing.NewHash(fields[0]),
+			TreeHash:  plumbing.NewHash(fields[1]),
+			Author:    author,
+			Committer: committer,
+			Message:   fields[9],
+		}
+		for _, parent := range strings.Fields(fields[2]) {
+			commit.ParentHashes = append(commit.ParentHashes, plumbing.NewHash(parent))
+		}
+		commits = append(commits, commit)
+	}
+	return commits, nil
+}
+
+func newSignature(name, email, date string) (object.Signature, error) {
+	when, err := time.Parse(time.RFC3339, date)
+	return object.Signature{Name: name, Email: email, When: when}, err
+}
@@ -0,0 +1,493 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+// A git backend implemented with go-git, which doesn't require a git binary.
+type goGitBackend struct {
+	path string
+	vcs  *Vcs
+	repo *git.Repository
+}
+
+func (b *goGitBackend) Repository() (*git.Repository, error) {
+	if b.repo == nil {
+		repo, err := git.PlainOpen(b.path)
+		if err != nil {
+			return nil, err
+		}
+		b.repo = repo
+	}
+	return b.repo, nil
+}
+
+func (b *goGitBackend) Clone() (err error) {
+	vcs := b.vcs
+	cloneOption := &git.CloneOptions{
+		URL:   vcs.Url,
+		Auth:  createCredentials(vcs),
+		Depth: vcs.Depth,
+		Tags:  getTagMode(vcs),
+	}
+	var branches []string
+	if vcs.SingleBranch {
+		if branches, err = listBranchesToClone(vcs); err != nil {
+			return
+		}
+		cloneOption.SingleBranch = true
+		cloneOption.ReferenceName = plumbing.NewBranchReferenceName(branches[0])
+	}
+	if b.repo, err = git.PlainClone(b.path, false, cloneOption); err != nil || len(branches) < 2 {
+		return
+	}
+	return fetchBranches(b.repo, vcs, branches[1:])
+}
+
+// go-git doesn't support pruning on fetch, therefore the deleted branches and tags are pruned after the fetch.
+func (b *goGitBackend) Fetch() error {
+	gitRepo, err := b.Repository()
+	if err != nil {
+		return err
+	}
+	vcs := b.vcs
+	remote, err := gitRepo.Remote(defaultRemote)
+	if err != nil {
+		return err
+	}
+	if vcs.SingleBranch {
+		var branches []string
+		if branches, err = listBranchesToClone(vcs); err != nil {
+			return err
+		}
+		err = fetchBranches(gitRepo, vcs, branches)
+	} else {
+		err = remote.Fetch(&git.FetchOptions{
+			RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", defaultRemote))},
+			Depth:    vcs.Depth,
+			Auth:     createCredentials(vcs),
+			Tags:     getTagMode(vcs),
+			Force:    true,
+		})
+	}
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	remoteRefs, err := remote.List(&git.ListOptions{Auth: createCredentials(vcs)})
+	if err != nil {
+		return err
+	}
+	return pruneReferences(gitRepo, remoteRefs)
+}
+
+func (b *goGitBackend) Checkout(ref string) error {
+	r, err := b.Repository()
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	opts := &git.CheckoutOptions{Force: true}
+	if plumbing.IsHash(ref) {
+		opts.Hash = plumbing.NewHash(ref)
+	} else {
+		opts.Branch = plumbing.ReferenceName(ref)
+	}
+	return w.Checkout(opts)
+}
+
+func (b *goGitBackend) LogRange(fromSha string) (commits []object.Commit, err error) {
+	r, err := b.Repository()
+	if err != nil {
+		return
+	}
+	if err = deepenUntilFound(fromSha, r, b.vcs); err != nil {
+		return
+	}
+	_, err = r.CommitObject(plumbing.NewHash(fromSha))
+	getLatestCommit := false
+	if err != nil {
+		log.Info("Commit sha: '" + fromSha + "' wasn't found in the commits log. This may be the result of force push command. As a result, scanning only the latest commit on this branch.")
+		getLatestCommit = true
+	}
+	cIter, err := r.Log(&git.LogOptions{})
+	if err != nil {
+		return
+	}
+	// Iterates over the commits from top to buttom. Save the commit hash till 'fromSha' is found.
+	err = cIter.ForEach(func(c *object.Commit) error {
+		found := c.Hash.String() == fromSha
+		if !found {
+			commits = append([]object.Commit{*c}, commits...)
+		}
+		if found || getLatestCommit {
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err == plumbing.ErrObjectNotFound && isShallow(r) {
+		// The parents of the oldest commit of a shallow clone are missing.
+		err = nil
+	}
+	return
+}
+
+func (b *goGitBackend) DeepenUntilFound(sha string) error {
+	r, err := b.Repository()
+	if err != nil {
+		return err
+	}
+	return deepenUntilFound(sha, r, b.vcs)
+}
+
+func (b *goGitBackend) IsAncestor(sha, descendantSha string) (bool, error) {
+	r, err := b.Repository()
+	if err != nil {
+		return false, err
+	}
+	commit, err := r.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return false, nil
+	}
+	descendant, err := r.CommitObject(plumbing.NewHash(descendantSha))
+	if err != nil {
+		return false, err
+	}
+	isAncestor, err := commit.IsAncestor(descendant)
+	if err == plumbing.ErrObjectNotFound && isShallow(r) {
+		// The walk reached the boundary of the shallow history without finding the commit.
+		return false, nil
+	}
+	return isAncestor, err
+}
+
+func (b *goGitBackend) Diff(fromSha, toSha string) ([]string, error) {
+	r, err := b.Repository()
+	if err != nil {
+		return nil, err
+	}
+	var trees []*object.Tree
+	for _, sha := range []string{fromSha, toSha} {
+		commit, err := r.CommitObject(plumbing.NewHash(sha))
+		if err != nil {
+			return nil, err
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, err
+		}
+		trees = append(trees, tree)
+	}
+	changes, err := object.DiffTree(trees[0], trees[1])
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+func (b *goGitBackend) Clean(excludes []string) error {
+	r, err := b.Repository()
+	if err != nil {
+		return err
+	}
+	files, dirs, err := findUntracked(b.path, r, excludes)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = os.Remove(filepath.Join(b.path, file)); err != nil {
+			return err
+		}
+	}
+	// The deepest directories are removed first. Directories which contain excluded paths are kept.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := filepath.Join(b.path, dirs[i])
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			if err = os.Remove(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *goGitBackend) DirtyPaths(excludes []string) ([]string, error) {
+	r, err := b.Repository()
+	if err != nil {
+		return nil, err
+	}
+	dirty, _, err := findUntracked(b.path, r, excludes)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	submodules := make(map[string]bool)
+	for _, entry := range idx.Entries {
+		if entry.Mode == filemode.Submodule {
+			submodules[entry.Name] = true
+		}
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	for path, fileStatus := range status {
+		// The untracked files, including the ignored ones, were found above.
+		if fileStatus.Worktree == git.Untracked || submodules[path] || (fileStatus.Worktree == git.Unmodified && fileStatus.Staging == git.Unmodified) {
+			continue
+		}
+		dirty = append(dirty, path)
+	}
+	sort.Strings(dirty)
+	return dirty, nil
+}
+
+// Returns the untracked files of the worktree at path, including the ignored ones, and the directories which don't contain tracked files.
+// Paths matching the gitignore patterns 'excludes' are skipped. The paths are relative to the worktree, separated by '/'.
+func findUntracked(path string, r *git.Repository, excludes []string) (files, dirs []string, err error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, nil, err
+	}
+	tracked := make(map[string]bool)
+	trackedDirs := make(map[string]bool)
+	for _, entry := range idx.Entries {
+		tracked[entry.Name] = true
+		for dir := filepath.ToSlash(filepath.Dir(entry.Name)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+			trackedDirs[dir] = true
+		}
+	}
+	matcher := newExcludeMatcher(excludes)
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == ".":
+			return nil
+		case rel == git.GitDirName || tracked[rel]:
+			// A tracked directory is a submodule.
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case matcher.Match(strings.Split(rel, "/"), info.IsDir()):
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case info.IsDir():
+			if !trackedDirs[rel] {
+				dirs = append(dirs, rel)
+			}
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	return files, dirs, err
+}
+
+// Fetch the given branches, into the remote branches of the default remote.
+func fetchBranches(gitRepo *git.Repository, vcs *Vcs, branches []string) error {
+	var refSpecs []config.RefSpec
+	for _, branch := range branches {
+		refSpecs = append(refSpecs, config.RefSpec(fmt.Sprintf("+%s:%s", plumbing.NewBranchReferenceName(branch), plumbing.NewRemoteReferenceName(defaultRemote, branch))))
+	}
+	err := gitRepo.Fetch(&git.FetchOptions{
+		RemoteName: defaultRemote,
+		RefSpecs:   refSpecs,
+		Depth:      vcs.Depth,
+		Auth:       createCredentials(vcs),
+		Tags:       getTagMode(vcs),
+		Force:      true,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+func getTagMode(vcs *Vcs) git.TagMode {
+	if vcs.NoTags {
+		return git.NoTags
+	}
+	return git.AllTags
+}
+
+func isShallow(r *git.Repository) bool {
+	shallows, err := r.Storer.Shallow()
+	return err == nil && len(shallows) > 0
+}
+
+// Deepen the history of a shallow clone, until the commit 'sha' is found or the depth reaches 'vcs.MaxDepth'.
+// The depth is doubled on each iteration.
+func deepenUntilFound(sha string, r *git.Repository, vcs *Vcs) error {
+	depth := vcs.Depth
+	if depth < 1 {
+		depth = 1
+	}
+	for {
+		if _, err := r.CommitObject(plumbing.NewHash(sha)); err == nil {
+			return nil
+		}
+		shallows, err := r.Storer.Shallow()
+		if err != nil || len(shallows) == 0 {
+			// The full history is available.
+			return err
+		}
+		if vcs.MaxDepth > 0 && depth >= vcs.MaxDepth {
+			log.Info(fmt.Sprintf("Commit '%s' wasn't found in the last %d commits.", sha, vcs.MaxDepth))
+			return nil
+		}
+		depth *= 2
+		if vcs.MaxDepth > 0 && depth > vcs.MaxDepth {
+			depth = vcs.MaxDepth
+		}
+		head, err := r.Head()
+		if err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Commit '%s' is not in the shallow history. Deepening the history to %d commits...", sha, depth))
+		if err = deepen(r, vcs, head.Hash(), depth); err != nil {
+			return err
+		}
+		if newShallows, err := r.Storer.Shallow(); err != nil || equalHashes(shallows, newShallows) {
+			return err
+		}
+	}
+}
+
+// Fetch the history of the commit 'hash' into a shallow clone, up to 'depth' commits.
+// go-git doesn't deepen an existing shallow clone on fetch, since the wanted commit already exists locally.
+// Therefore, the upload-pack request is sent directly.
+func deepen(r *git.Repository, vcs *Vcs, hash plumbing.Hash, depth int) (err error) {
+	ep, err := transport.NewEndpoint(vcs.Url)
+	if err != nil {
+		return err
+	}
+	c, err := client.NewClient(ep)
+	if err != nil {
+		return err
+	}
+	session, err := c.NewUploadPackSession(ep, createCredentials(vcs))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := session.Close(); err == nil {
+			err = e
+		}
+	}()
+	ar, err := session.AdvertisedReferences()
+	if err != nil {
+		return err
+	}
+	req := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
+	req.Wants = []plumbing.Hash{hash}
+	req.Depth = packp.DepthCommits(depth)
+	if err = req.Capabilities.Set(capability.Shallow); err != nil {
+		return err
+	}
+	if req.Shallows, err = r.Storer.Shallow(); err != nil {
+		return err
+	}
+	resp, err := session.UploadPack(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := resp.Close(); err == nil {
+			err = e
+		}
+	}()
+	if err = r.Storer.SetShallow(updateShallows(req.Shallows, resp.ShallowUpdate)); err != nil {
+		return err
+	}
+	var reader io.Reader = resp
+	if req.Capabilities.Supports(capability.Sideband64k) {
+		reader = sideband.NewDemuxer(sideband.Sideband64k, resp)
+	} else if req.Capabilities.Supports(capability.Sideband) {
+		reader = sideband.NewDemuxer(sideband.Sideband, resp)
+	}
+	return packfile.UpdateObjectStorage(r.Storer, reader)
+}
+
+// Returns the shallow commits after a shallow update.
+func updateShallows(shallows []plumbing.Hash, update packp.ShallowUpdate) []plumbing.Hash {
+	var result []plumbing.Hash
+	for _, hash := range append(shallows, update.Shallows...) {
+		if !containsHash(update.Unshallows, hash) && !containsHash(result, hash) {
+			result = append(result, hash)
+		}
+	}
+	return result
+}
+
+func containsHash(hashes []plumbing.Hash, hash plumbing.Hash) bool {
+	for _, h := range hashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func equalHashes(a, b []plumbing.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, hash := range a {
+		if !containsHash(b, hash) {
+			return false
+		}
+	}
+	return true
+}
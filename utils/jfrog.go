@@ -1,27 +1,25 @@
 package utils
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/exec"
-	"strconv"
-	"strings"
 
 	"github.com/jfrog/jfrog-client-go/artifactory"
 	"github.com/jfrog/jfrog-client-go/artifactory/auth"
 	"github.com/jfrog/jfrog-client-go/artifactory/buildinfo"
-	"github.com/jfrog/jfrog-client-go/artifactory/services"
 	"github.com/jfrog/jfrog-client-go/config"
 	"github.com/jfrog/jfrog-client-go/utils/log"
 )
 
 const (
-	// Name of downloaded build-info file from Artifactory.
-	buildInfoFile = "buildinfo.json"
 	// A unique ID for a new Artifactory server configuration.
 	serverId = "vcs-superhighway"
+	// Build name template for tags, if not configured.
+	defaultTagBuildName = "${projectName}-${tag|sanitize}"
 
 	// Environment variables
 	// The next build number to be published by JFrog CLI (Optional).
@@ -29,48 +27,138 @@ const (
 	// The build name & number to be used by JFrog CLI commands.
 	jfrogBuildName   = "JFROG_CLI_BUILD_NAME"
 	jfrogBuildNumber = "JFROG_CLI_BUILD_NUMBER"
+	// The JFrog Project key to be used by JFrog CLI commands.
+	jfrogBuildProject = "JFROG_CLI_BUILD_PROJECT"
+
+	// The number of bytes kept from the standard error of a failed command.
+	cmdErrorStderrSize = 4096
 )
 
 // Configure JFrog CLI with Artifactory servers, which can later be used in the other commands.
 func CreateArtServer(c *BuildConfig) error {
 	log.Info("Setting up Artifactory server on agent")
 	configCmd := fmt.Sprintf("jfrog rt c %s --interactive=false --url=%s --user=%s --password=%s ", serverId, c.Jfrog.ArtUrl, c.Jfrog.User, c.Jfrog.Password)
+	if c.Network != nil && c.Network.InsecureSkipVerify {
+		configCmd += "--insecure-tls=true "
+	}
 	return RunCmd("", configCmd)
 }
 
 // Runs build command at 'projectPath'.
 // Build-name & build-number are expected to be set as env vars
 func Build(buildCommand, projectPath string) error {
-	log.Info("Executing build command '%s'...", buildCommand)
+	SetLogStage(BuildStage)
+	log.Info("Executing build command '" + buildCommand + "'...")
 	return RunCmd(projectPath, buildCommand)
 }
 
 // Build-name & build-number are expected to be set as env vars
 func Bag(projectPath string) error {
+	SetLogStage(BagStage)
 	log.Info("Collecting VCS details...")
 	return RunCmd(projectPath, "jfrog rt bag --server-id="+serverId)
 }
 
 // Build-name & build-number are expected to be set as env vars
-func Publish() error {
+func Publish(projectKey string) error {
+	SetLogStage(PublishStage)
 	log.Info("Publishing the build to Artifactory...")
-	return RunCmd("", "jfrog rt bp --server-id="+serverId)
+	return Retry("Publishing the build", func() error {
+		return RunCmd("", "jfrog rt bp --server-id="+serverId+projectFlag(projectKey))
+	})
 }
 
 // Build-name & build-number are expected to be set as env vars
-func BuildScan() error {
+// Returns the result of the scan, along with the error of the command. Violations fail the command, see IsBuildViolationsError.
+func BuildScan(projectKey string) (*ScanResult, error) {
+	SetLogStage(ScanStage)
 	log.Info("Scanning the published build with Xray...")
-	return RunCmd("", "jfrog rt bs --server-id="+serverId)
+	var output bytes.Buffer
+	err := Retry("Scanning the build", func() error {
+		output.Reset()
+		return runCmd("", "jfrog rt bs --server-id="+serverId+projectFlag(projectKey), &output)
+	})
+	if err != nil && !IsBuildViolationsError(err) {
+		return nil, err
+	}
+	result, parseErr := parseScanResult(output.Bytes())
+	if parseErr != nil {
+		log.Warn("Failed to parse the build scan result: " + parseErr.Error())
+	}
+	return result, err
+}
+
+// Returns true if the build scan failed because Xray found violations.
+func IsBuildViolationsError(err error) bool {
+	var cmdErr *cmdError
+	return errors.As(err, &cmdErr) && cmdErr.exitCode == vulnerableBuildExitCode
+}
+
+// Returns the '--project' flag of JFrog CLI commands, or an empty string without a project key.
+func projectFlag(projectKey string) string {
+	if projectKey == "" {
+		return ""
+	}
+	return " --project=" + projectKey
 }
 
 // Run a command in the bash shell. If 'runAt' is specified, the command will be executed at this path context.
+// The command output is written to the log, see commandOutputWriter, and to the commit log if it is archived. If the command fails, a cmdError is returned.
 func RunCmd(runAt string, cmd string) error {
+	return runCmd(runAt, cmd, nil)
+}
+
+// Run a command like RunCmd. If 'stdoutCapture' is not nil, the standard output is also written to it.
+func runCmd(runAt, cmd string, stdoutCapture io.Writer) error {
 	cmds := exec.Command("bash", "-c", cmd)
 	if runAt != "" {
 		cmds.Dir = runAt
 	}
-	cmds.Stdout, cmds.Stderr = os.Stdout, os.Stderr
-	return cmds.Run()
+	stdout, flushStdout := commandOutputWriter("stdout", os.Stdout)
+	stderrOutput, flushStderr := commandOutputWriter("stderr", os.Stderr)
+	stdout, stderrOutput = withCommitLog(stdout, stderrOutput)
+	if stdoutCapture != nil {
+		stdout = io.MultiWriter(stdout, stdoutCapture)
+	}
+	stderr := &tailWriter{size: cmdErrorStderrSize}
+	cmds.Stdout, cmds.Stderr = stdout, io.MultiWriter(stderrOutput, stderr)
+	err := cmds.Run()
+	flushStdout()
+	flushStderr()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return &cmdError{err: exitErr, exitCode: exitErr.ExitCode(), stderr: string(stderr.data)}
+	}
+	return err
+}
+
+// A command which exited with a non-zero exit code.
+// The end of its standard error is kept, to tell whether the failure is transient.
+type cmdError struct {
+	err      error
+	exitCode int
+	stderr   string
+}
+
+func (e *cmdError) Error() string {
+	return e.err.Error()
+}
+
+func (e *cmdError) Unwrap() error {
+	return e.err
+}
+
+// Keeps the last 'size' bytes written to it.
+type tailWriter struct {
+	size int
+	data []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.data = append(w.data, p...)
+	if len(w.data) > w.size {
+		w.data = w.data[len(w.data)-w.size:]
+	}
+	return len(p), nil
 }
 
 func DeleteArtServer() error {
@@ -96,43 +184,23 @@ func CreateBuildToolConfigs(runAt string, c *BuildConfig) (err error) {
 	return
 }
 
-// Set jfrog cli build-name and build-number as env vars, to be use by the agent during the build.
-func SetBuildProps(buildName, commitSha, prevBuildNumber, runNumber string) (err error) {
+// Set jfrog cli build-name, build-number and optional project key as env vars, to be use by the agent during the build.
+func SetBuildProps(buildName, buildNumber, projectKey string) error {
 	log.Info("Generating JFrog CLI build environment variables...")
 	if err := os.Setenv(jfrogBuildName, buildName); err != nil {
 		return err
 	}
-	buildNumber, err := GetNextBuildNumber(prevBuildNumber)
-	if err != nil {
-		return err
-	}
-	return os.Setenv(jfrogBuildNumber, fmt.Sprintf("%s.%s-%s", buildNumber, runNumber, commitSha))
-}
-
-// Return the value of 'BUILD_NUMBER' env var.
-// If not configured, return the last run build number incremented by 1.
-func GetNextBuildNumber(prevBuildNumber string) (nextBuildNumber string, err error) {
-	bn := 0
-	if buildNumber := os.Getenv(buildNumber); buildNumber != "" {
-		bn, err = strconv.Atoi(buildNumber)
-		if err != nil {
-			return
-		}
-	} else {
-		prevBuildNumberIdx := strings.Index(prevBuildNumber, ".")
-		bn, err = strconv.Atoi(prevBuildNumber[:prevBuildNumberIdx])
-		if err != nil {
-			return
+	if projectKey != "" {
+		if err := os.Setenv(jfrogBuildProject, projectKey); err != nil {
+			return err
 		}
-		bn++
 	}
-	nextBuildNumber = strconv.Itoa(bn)
-	return
+	return os.Setenv(jfrogBuildNumber, buildNumber)
 }
 
 func UnsetJfrogBuildProps() error {
 	var err error
-	for _, env := range []string{jfrogBuildName, jfrogBuildNumber} {
+	for _, env := range []string{jfrogBuildName, jfrogBuildNumber, jfrogBuildProject} {
 		if os.Getenv(env) == "" {
 			continue
 		}
@@ -143,50 +211,77 @@ func UnsetJfrogBuildProps() error {
 	return nil
 }
 
-// Gets the latest build number from Artifactory.
-// If the build does not exist, return an error.
-func GetLatestBuildInfo(ArtifactoryServicesManager artifactory.ArtifactoryServicesManager, buildName string) (buildInfo *buildinfo.BuildInfo, err error) {
-	params := services.NewDownloadParams()
-	params.Pattern = "artifactory-build-info/" + buildName + "/*"
-	params.Target = buildInfoFile
-	params.SortBy = []string{"created"}
-	params.SortOrder = "desc"
-	params.Limit = 1
-	params.Flat = true
-	log.Info("Searching the latest build for '%s' build...", buildName)
-	var totalDownloaded int
-	totalDownloaded, _, err = ArtifactoryServicesManager.DownloadFiles(params)
+// Gets the latest build-info of buildName from Artifactory, using the build API.
+// Returns nil if no build was published under buildName.
+func GetLatestBuildInfo(ArtifactoryServicesManager artifactory.ArtifactoryServicesManager, buildName string, jfrog *JfrogDetails) (buildInfo *buildinfo.BuildInfo, err error) {
+	log.Info("Searching the latest build for '" + buildName + "' build...")
+	err = Retry("Getting the latest build of '"+buildName+"'", func() (err error) {
+		buildInfo, err = findLatestBuildInfo(ArtifactoryServicesManager, buildName, jfrog)
+		return
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to download build '%s' from Artifactory, Error: '%s'", buildName, err.Error())
+		return nil, fmt.Errorf("failed to get build '%s' from Artifactory, Error: '%s'", buildName, err.Error())
 	}
-	if totalDownloaded == 0 {
-		return nil, fmt.Errorf("build '%s' is not found in Artifactory", buildName)
+	if buildInfo == nil {
+		log.Info("Build '" + buildName + "' is not found in Artifactory")
 	}
-	defer func() {
-		if e := os.Remove(buildInfoFile); err == nil {
-			err = e
-		}
-	}()
-	var data []byte
-	buildInfo = new(buildinfo.BuildInfo)
-	data, err = ioutil.ReadFile(buildInfoFile)
+	return buildInfo, nil
+}
+
+// Create the branch build name from the build config.
+// See renderBuildName for the template syntax. '${branch}' is replaced with the branch name.
+func GetBranchBuildName(branch string, c *BuildConfig) (string, error) {
+	buildName, err := resolveBuildName(c.Jfrog.BuildName, branch, "", c)
 	if err != nil {
-		return
+		return "", err
 	}
-	err = json.Unmarshal(data, buildInfo)
-	return
+	log.Info("The associate branch build-name is '" + buildName + "'")
+	return buildName, nil
 }
 
-// Create the branch build name from the build config.
-// replace '${projectName}' with BuildConfig.ProjectName and '${branch}' with branch name.
-func GetBranchBuildName(branch string, c *BuildConfig) string {
-	buildName := strings.Replace(c.Jfrog.BuildName, "${projectName}", c.ProjectName, -1)
-	buildName = strings.Replace(buildName, "${branch}", branch, -1)
-	log.Info("The associate branch build-name is '%s'", buildName)
-	return buildName
+// Create the tag build name from the build config.
+// See renderBuildName for the template syntax. '${tag}' is replaced with the tag name.
+func GetTagBuildName(tag string, c *BuildConfig) (string, error) {
+	template := c.Jfrog.TagBuildName
+	if template == "" {
+		template = defaultTagBuildName
+	}
+	buildName, err := resolveBuildName(template, "", tag, c)
+	if err != nil {
+		return "", err
+	}
+	log.Info("The associate tag build-name is '" + buildName + "'")
+	return buildName, nil
+}
+
+func resolveBuildName(template, branch, tag string, c *BuildConfig) (string, error) {
+	vars := map[string]string{
+		"projectName": c.ProjectName,
+		"module":      c.Module,
+		"branch":      branch,
+		"tag":         tag,
+	}
+	if c.Vcs != nil {
+		vars["repo"] = getRepoName(c.Vcs.Url)
+	}
+	return renderBuildName(template, vars)
+}
+
+// Returns true if at least one build was published to Artifactory under 'buildName'.
+func IsBuildExists(ArtifactoryServicesManager artifactory.ArtifactoryServicesManager, buildName string, jfrog *JfrogDetails) (bool, error) {
+	var buildInfo *buildinfo.BuildInfo
+	err := Retry("Searching build '"+buildName+"'", func() (err error) {
+		buildInfo, err = findLatestBuildInfo(ArtifactoryServicesManager, buildName, jfrog)
+		return
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to search build '%s' in Artifactory, Error: '%s'", buildName, err.Error())
+	}
+	return buildInfo != nil, nil
 }
 
-func createServiceManager(buildConfig *BuildConfig) (artifactory.ArtifactoryServicesManager, error) {
+// 'certsDir' is a directory of extra trusted certificates. Optional.
+func createServiceManager(buildConfig *BuildConfig, certsDir string) (artifactory.ArtifactoryServicesManager, error) {
 	rtDetails := auth.NewArtifactoryDetails()
 	rtDetails.SetUrl(buildConfig.Jfrog.ArtUrl)
 	rtDetails.SetUser(buildConfig.Jfrog.User)
@@ -194,6 +289,8 @@ func createServiceManager(buildConfig *BuildConfig) (artifactory.ArtifactoryServ
 
 	serviceConfig, err := config.NewConfigBuilder().
 		SetServiceDetails(rtDetails).
+		SetCertificatesPath(certsDir).
+		SetInsecureTls(buildConfig.Network != nil && buildConfig.Network.InsecureSkipVerify).
 		SetDryRun(false).
 		Build()
 	if err != nil {
@@ -202,10 +299,10 @@ func createServiceManager(buildConfig *BuildConfig) (artifactory.ArtifactoryServ
 	return artifactory.New(&rtDetails, serviceConfig)
 }
 
-// Returns the vcs revision from build-info.
+// Returns the vcs revision from build-info. The vcs URLs are compared in their canonical form, see NormalizeVcsUrl.
 func getBuildCommitSha(bi *buildinfo.BuildInfo, vcsUrl string) (string, error) {
 	for _, vcs := range bi.VcsList {
-		if vcs.Url == vcsUrl {
+		if IsSameVcsUrl(vcs.Url, vcsUrl) {
 			return vcs.Revision, nil
 		}
 	}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTagBuildName(t *testing.T) {
+	c := &BuildConfig{ProjectName: "npm-example", Jfrog: &JfrogDetails{BuildName: "${projectName}-${branch}"}}
+	buildName, err := GetTagBuildName("release/v1.0.0", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "npm-example-release-v1.0.0", buildName)
+	c.Jfrog.TagBuildName = "${projectName}-release-${tag}"
+	buildName, err = GetTagBuildName("v1.0.0", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "npm-example-release-v1.0.0", buildName)
+}
+
+func TestGetBranchBuildName(t *testing.T) {
+	c := &BuildConfig{
+		ProjectName: "npm-example",
+		Vcs:         &Vcs{Url: "https://github.com/Or-Geva/npm-example.git"},
+		Jfrog:       &JfrogDetails{BuildName: "${repo}-${branch|sanitize|lower}"},
+	}
+	buildName, err := GetBranchBuildName("feature/Foo", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "npm-example-feature-foo", buildName)
+	c.Jfrog.BuildName = "${projectName}-${branch}"
+	_, err = GetBranchBuildName("feature/foo", c)
+	assert.Error(t, err)
+}
+
+func TestSetBuildProps(t *testing.T) {
+	assert.NoError(t, SetBuildProps("build", "1", "proj"))
+	assert.Equal(t, "build", os.Getenv(jfrogBuildName))
+	assert.Equal(t, "1", os.Getenv(jfrogBuildNumber))
+	assert.Equal(t, "proj", os.Getenv(jfrogBuildProject))
+	assert.NoError(t, UnsetJfrogBuildProps())
+	assert.Empty(t, os.Getenv(jfrogBuildProject))
+
+	assert.Equal(t, " --project=proj", projectFlag("proj"))
+	assert.Empty(t, projectFlag(""))
+}
@@ -0,0 +1,263 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+const (
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	// LFS pointer files are always smaller than this size.
+	lfsPointerMaxSize = 1024
+	lfsMediaType      = "application/vnd.git-lfs+json"
+)
+
+// A Git LFS pointer file, which is checked out instead of the real file content.
+type lfsPointer struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+type lfsBatchRequest struct {
+	Operation string       `json:"operation"`
+	Transfers []string     `json:"transfers"`
+	Objects   []lfsPointer `json:"objects"`
+}
+
+type lfsBatchResponse struct {
+	Objects []struct {
+		lfsPointer
+		Actions struct {
+			Download *struct {
+				Href   string            `json:"href"`
+				Header map[string]string `json:"header"`
+			} `json:"download"`
+		} `json:"actions"`
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	} `json:"objects"`
+}
+
+// Replace the Git LFS pointer files of the checked out commit with their content.
+// The content is downloaded from the LFS server using the vcs credentials.
+func SmudgeLfsFiles(projectPath string, r *git.Repository, vcs *Vcs) error {
+	pointers, err := getLfsPointers(r)
+	if err != nil || len(pointers) == 0 {
+		return err
+	}
+	log.Info(fmt.Sprintf("Downloading %d Git LFS files...", len(pointers)))
+	var objects []lfsPointer
+	requested := make(map[string]bool)
+	for _, pointer := range pointers {
+		if !requested[pointer.Oid] {
+			requested[pointer.Oid] = true
+			objects = append(objects, pointer)
+		}
+	}
+	var batch *lfsBatchResponse
+	err = Retry("Requesting the Git LFS download links", func() (err error) {
+		batch, err = requestLfsBatch(vcs, objects)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	for _, object := range batch.Objects {
+		if object.Error != nil {
+			return fmt.Errorf("failed to download Git LFS object '%s': %d %s", object.Oid, object.Error.Code, object.Error.Message)
+		}
+		if object.Actions.Download == nil {
+			return fmt.Errorf("the LFS server didn't return a download link for Git LFS object '%s'", object.Oid)
+		}
+		download := object.Actions.Download
+		for path, pointer := range pointers {
+			if pointer.Oid != object.Oid {
+				continue
+			}
+			// A failed download keeps the pointer file, so it can be retried.
+			err = Retry("Downloading Git LFS file '"+path+"'", func() error {
+				return downloadLfsObject(filepath.Join(projectPath, path), pointer, download.Href, download.Header, vcs)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the LFS pointer files in the HEAD commit, mapped by their path.
+func getLfsPointers(r *git.Repository) (map[string]lfsPointer, error) {
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	files, err := commit.Files()
+	if err != nil {
+		return nil, err
+	}
+	pointers := make(map[string]lfsPointer)
+	err = files.ForEach(func(f *object.File) error {
+		if f.Size >= lfsPointerMaxSize || !f.Mode.IsFile() {
+			return nil
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		if pointer, ok := parseLfsPointer(content); ok {
+			pointers[f.Name] = pointer
+		}
+		return nil
+	})
+	return pointers, err
+}
+
+// Parse the content of an LFS pointer file. Returns false if the content isn't a pointer.
+func parseLfsPointer(content string) (lfsPointer, bool) {
+	pointer := lfsPointer{Size: -1}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	if !scanner.Scan() || scanner.Text() != lfsPointerVersion {
+		return pointer, false
+	}
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) != 2 {
+			return pointer, false
+		}
+		switch parts[0] {
+		case "oid":
+			pointer.Oid = strings.TrimPrefix(parts[1], "sha256:")
+		case "size":
+			size, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return pointer, false
+			}
+			pointer.Size = size
+		}
+	}
+	return pointer, len(pointer.Oid) == sha256.Size*2 && pointer.Size >= 0
+}
+
+// Returns the LFS server URL. Defaults to '<vcs url>.git/info/lfs'.
+func getLfsUrl(vcs *Vcs) string {
+	if vcs.LfsUrl != "" {
+		return strings.TrimSuffix(vcs.LfsUrl, "/")
+	}
+	lfsUrl := strings.TrimSuffix(vcs.Url, "/")
+	if !strings.HasSuffix(lfsUrl, ".git") {
+		lfsUrl += ".git"
+	}
+	return lfsUrl + "/info/lfs"
+}
+
+func requestLfsBatch(vcs *Vcs, objects []lfsPointer) (*lfsBatchResponse, error) {
+	body, err := json.Marshal(&lfsBatchRequest{Operation: "download", Transfers: []string{"basic"}, Objects: objects})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, getLfsUrl(vcs)+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	setBasicAuth(req, vcs)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Git LFS batch request failed: %s", resp.Status)
+	}
+	batch := new(lfsBatchResponse)
+	return batch, json.NewDecoder(resp.Body).Decode(batch)
+}
+
+// Download an LFS object into path, and verify its size and checksum.
+func downloadLfsObject(path string, pointer lfsPointer, href string, header map[string]string, vcs *Vcs) error {
+	req, err := http.NewRequest(http.MethodGet, href, nil)
+	if err != nil {
+		return err
+	}
+	// Without headers from the LFS server, the vcs credentials are only sent to the LFS server itself.
+	if len(header) == 0 && isSameOrigin(href, getLfsUrl(vcs)) {
+		setBasicAuth(req, vcs)
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download Git LFS object '%s': %s", pointer.Oid, resp.Status)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// Download to a temporary file, to keep the pointer file if the download fails.
+	tmpPath := path + ".lfs-download"
+	defer os.Remove(tmpPath)
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(resp.Body, pointer.Size+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size != pointer.Size || hex.EncodeToString(hash.Sum(nil)) != pointer.Oid {
+		return fmt.Errorf("the downloaded Git LFS object '%s' doesn't match its pointer", pointer.Oid)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Returns true if both URLs have the same scheme and host.
+func isSameOrigin(first, second string) bool {
+	firstUrl, err := url.Parse(first)
+	if err != nil {
+		return false
+	}
+	secondUrl, err := url.Parse(second)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(firstUrl.Scheme, secondUrl.Scheme) && strings.EqualFold(firstUrl.Host, secondUrl.Host)
+}
+
+func setBasicAuth(req *http.Request, vcs *Vcs) {
+	user, password := getCredentials(vcs)
+	if user != "" || password != "" {
+		req.SetBasicAuth(user, password)
+	}
+}
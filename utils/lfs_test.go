@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLfsPointer(t *testing.T) {
+	oid := "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+	pointer, ok := parseLfsPointer(lfsPointerVersion + "\noid sha256:" + oid + "\nsize 12345\n")
+	assert.True(t, ok)
+	assert.Equal(t, lfsPointer{Oid: oid, Size: 12345}, pointer)
+	for _, content := range []string{
+		"",
+		"just a text file",
+		lfsPointerVersion + "\noid sha256:1234\nsize 12345\n",
+		lfsPointerVersion + "\noid sha256:" + oid + "\n",
+		lfsPointerVersion + "\noid sha256:" + oid + "\nsize big\n",
+	} {
+		_, ok = parseLfsPointer(content)
+		assert.False(t, ok, content)
+	}
+}
+
+func TestGetLfsUrl(t *testing.T) {
+	assert.Equal(t, "https://github.com/jfrog/project.git/info/lfs", getLfsUrl(&Vcs{Url: "https://github.com/jfrog/project.git"}))
+	assert.Equal(t, "https://github.com/jfrog/project.git/info/lfs", getLfsUrl(&Vcs{Url: "https://github.com/jfrog/project"}))
+	assert.Equal(t, "https://lfs.jfrog.com", getLfsUrl(&Vcs{Url: "https://github.com/jfrog/project", LfsUrl: "https://lfs.jfrog.com/"}))
+}
+
+func TestSmudgeLfsFiles(t *testing.T) {
+	content := []byte("vendored dependencies archive")
+	checksum := sha256.Sum256(content)
+	oid := hex.EncodeToString(checksum[:])
+
+	// A local LFS server stand-in.
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/project.git/info/lfs/objects/batch":
+			assert.Equal(t, lfsMediaType, r.Header.Get("Accept"))
+			req := new(lfsBatchRequest)
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(req))
+			assert.Equal(t, "download", req.Operation)
+			assert.Equal(t, []lfsPointer{{Oid: oid, Size: int64(len(content))}}, req.Objects)
+			fmt.Fprintf(w, `{"objects":[{"oid":"%s","size":%d,"actions":{"download":{"href":"%s/objects/%s"}}}]}`, oid, len(content), server.URL, oid)
+		case "/objects/" + oid:
+			_, err := w.Write(content)
+			assert.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	r, path := createRepoWithFiles(t, map[string]string{
+		"archive.tgz":    lfsPointerVersion + "\noid sha256:" + oid + "\nsize " + fmt.Sprint(len(content)) + "\n",
+		"copy.tgz":       lfsPointerVersion + "\noid sha256:" + oid + "\nsize " + fmt.Sprint(len(content)) + "\n",
+		"package.json":   "{}",
+		".gitattributes": "*.tgz filter=lfs diff=lfs merge=lfs -text\n",
+	})
+	vcs := &Vcs{Url: server.URL + "/project.git", User: "user", Token: "token"}
+	assert.NoError(t, SmudgeLfsFiles(path, r, vcs))
+	for _, file := range []string{"archive.tgz", "copy.tgz"} {
+		actual, err := ioutil.ReadFile(filepath.Join(path, file))
+		assert.NoError(t, err)
+		assert.Equal(t, content, actual)
+	}
+
+	// Wrong credentials.
+	vcs.Token = "wrong"
+	assert.Error(t, SmudgeLfsFiles(path, r, vcs))
+}
+
+func TestSmudgeLfsFilesFromOtherHost(t *testing.T) {
+	content := []byte("vendored dependencies archive")
+	checksum := sha256.Sum256(content)
+	oid := hex.EncodeToString(checksum[:])
+
+	// The objects are stored on another host, such as a cloud storage.
+	var authorization []string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		_, err := w.Write(content)
+		assert.NoError(t, err)
+	}))
+	defer storage.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"objects":[{"oid":"%s","size":%d,"actions":{"download":{"href":"%s/objects/%s"}}}]}`, oid, len(content), storage.URL, oid)
+	}))
+	defer server.Close()
+
+	r, path := createRepoWithFiles(t, map[string]string{
+		"archive.tgz": lfsPointerVersion + "\noid sha256:" + oid + "\nsize " + fmt.Sprint(len(content)) + "\n",
+	})
+	assert.NoError(t, SmudgeLfsFiles(path, r, &Vcs{Url: server.URL + "/project.git", User: "user", Token: "token"}))
+	// The vcs credentials aren't sent to the other host.
+	assert.Equal(t, []string{""}, authorization)
+	actual, err := ioutil.ReadFile(filepath.Join(path, "archive.tgz"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, actual)
+}
+
+func TestIsSameOrigin(t *testing.T) {
+	assert.True(t, isSameOrigin("https://github.com/jfrog/project.git/info/lfs/objects/1", "https://github.com/jfrog/project.git/info/lfs"))
+	assert.False(t, isSameOrigin("http://github.com/objects/1", "https://github.com/jfrog/project.git/info/lfs"))
+	assert.False(t, isSameOrigin("https://github.com:8443/objects/1", "https://github.com/jfrog/project.git/info/lfs"))
+	assert.False(t, isSameOrigin("https://storage.example.com/objects/1", "https://github.com/jfrog/project.git/info/lfs"))
+}
+
+// Create a repository with a single commit, containing the given files.
+func createRepoWithFiles(t *testing.T, files map[string]string) (*git.Repository, string) {
+	path, cleanup := createTempDir(t)
+	t.Cleanup(cleanup)
+	r, err := git.PlainInit(path, false)
+	assert.NoError(t, err)
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+	for name, content := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(path, name), []byte(content), 0644))
+		_, err = w.Add(name)
+		assert.NoError(t, err)
+	}
+	_, err = w.Commit("Add files", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@jfrog.com"}})
+	assert.NoError(t, err)
+	return r, path
+}
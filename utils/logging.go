@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+const (
+	TextLogFormat = "text"
+	JsonLogFormat = "json"
+
+	// The source of the agent log records. The output of commands is tagged with its stream, 'stdout' or 'stderr'.
+	agentLogSource = "agent"
+)
+
+// The logging configuration.
+type Logging struct {
+	// One of 'text' (default) or 'json', which writes a JSON record per line with the project, branch, commit and stage.
+	Format string `yaml:"format"`
+	// One of 'ERROR', 'WARN', 'INFO' (default) or 'DEBUG'. In the 'json' format, the stderr of commands is logged at any level.
+	Level string `yaml:"level"`
+}
+
+// The context of the log records, set as the agent progresses.
+type LogContext struct {
+	Project string
+	Branch  string
+	Commit  string
+	Stage   string
+}
+
+var (
+	logContext      LogContext
+	logContextMutex sync.Mutex
+	// The logger of the command outputs in the 'json' format, or nil to pass them through to the standard streams.
+	commandLogger *jsonLogger
+)
+
+// Set the logger according to the logging configuration.
+func ConfigureLogging(logging *Logging) error {
+	if logging == nil {
+		logging = &Logging{}
+	}
+	level, err := parseLogLevel(logging.Level)
+	if err != nil {
+		return err
+	}
+	switch logging.Format {
+	case "", TextLogFormat:
+		log.SetLogger(log.NewLogger(level, nil))
+		commandLogger = nil
+	case JsonLogFormat:
+		commandLogger = newJsonLogger(level, os.Stderr)
+		log.SetLogger(commandLogger)
+	default:
+		return fmt.Errorf("unknown log format '%s', expecting one of: %s, %s", logging.Format, TextLogFormat, JsonLogFormat)
+	}
+	return nil
+}
+
+func parseLogLevel(level string) (log.LevelType, error) {
+	switch strings.ToUpper(level) {
+	case "ERROR":
+		return log.ERROR, nil
+	case "WARN":
+		return log.WARN, nil
+	case "", "INFO":
+		return log.INFO, nil
+	case "DEBUG":
+		return log.DEBUG, nil
+	}
+	return log.INFO, fmt.Errorf("unknown log level '%s', expecting one of: ERROR, WARN, INFO, DEBUG", level)
+}
+
+// Set the project, branch and commit of the next log records, and clear the stage.
+func SetLogContext(project, branch, commit string) {
+	logContextMutex.Lock()
+	defer logContextMutex.Unlock()
+	logContext = LogContext{Project: project, Branch: branch, Commit: commit}
+}
+
+// Set the stage of the next log records, such as 'build' or 'publish'.
+func SetLogStage(stage string) {
+	logContextMutex.Lock()
+	defer logContextMutex.Unlock()
+	logContext.Stage = stage
+}
+
+func getLogContext() LogContext {
+	logContextMutex.Lock()
+	defer logContextMutex.Unlock()
+	return logContext
+}
+
+// A JSON log record.
+type logRecord struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	Source  string `json:"source"`
+	Project string `json:"project,omitempty"`
+	Branch  string `json:"branch,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Stage   string `json:"stage,omitempty"`
+}
+
+// A logger which writes a JSON record per line, with the current log context.
+type jsonLogger struct {
+	level  log.LevelType
+	writer io.Writer
+	output io.Writer
+	mutex  sync.Mutex
+}
+
+func newJsonLogger(level log.LevelType, writer io.Writer) *jsonLogger {
+	return &jsonLogger{level: level, writer: writer, output: os.Stdout}
+}
+
+func (l *jsonLogger) GetLogLevel() log.LevelType {
+	return l.level
+}
+
+func (l *jsonLogger) SetLogLevel(level log.LevelType) {
+	l.level = level
+}
+
+func (l *jsonLogger) SetOutputWriter(writer io.Writer) {
+	l.output = writer
+}
+
+func (l *jsonLogger) SetLogsWriter(writer io.Writer) {
+	if writer == nil {
+		writer = os.Stderr
+	}
+	l.writer = writer
+}
+
+func (l *jsonLogger) Debug(a ...interface{}) {
+	l.log(log.DEBUG, "debug", a...)
+}
+
+func (l *jsonLogger) Info(a ...interface{}) {
+	l.log(log.INFO, "info", a...)
+}
+
+func (l *jsonLogger) Warn(a ...interface{}) {
+	l.log(log.WARN, "warn", a...)
+}
+
+func (l *jsonLogger) Error(a ...interface{}) {
+	l.log(log.ERROR, "error", a...)
+}
+
+// The output, such as the dry-run plan, is written to the output writer, a record per line.
+func (l *jsonLogger) Output(a ...interface{}) {
+	for _, line := range strings.Split(strings.TrimSuffix(fmt.Sprintln(a...), "\n"), "\n") {
+		l.write(l.output, "output", line, agentLogSource)
+	}
+}
+
+func (l *jsonLogger) log(level log.LevelType, levelName string, a ...interface{}) {
+	if l.level >= level {
+		l.write(l.writer, levelName, strings.TrimSuffix(fmt.Sprintln(a...), "\n"), agentLogSource)
+	}
+}
+
+func (l *jsonLogger) write(writer io.Writer, level, message, source string) {
+	context := getLogContext()
+	record, err := json.Marshal(&logRecord{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Level:   level,
+		Message: message,
+		Source:  source,
+		Project: context.Project,
+		Branch:  context.Branch,
+		Commit:  context.Commit,
+		Stage:   context.Stage,
+	})
+	if err != nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, _ = writer.Write(append(record, '\n'))
+}
+
+// Returns the writer of a command output stream, 'stdout' or 'stderr', and a func to call after the command exits.
+// In the 'json' format, each line of the output is logged as a record tagged with the stream. Otherwise, the output is passed through.
+// The lines of stdout are info records. The lines of stderr are warn records, logged at any log level, to keep the cause of a failed command.
+func commandOutputWriter(stream string, passThrough io.Writer) (io.Writer, func()) {
+	if commandLogger == nil {
+		return passThrough, func() {}
+	}
+	writer := &lineWriter{logger: commandLogger, source: stream}
+	return writer, writer.flush
+}
+
+// Logs each line written to it.
+type lineWriter struct {
+	logger *jsonLogger
+	source string
+	buf    bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.logLine(string(w.buf.Next(i + 1)))
+	}
+}
+
+// Log the last line, if it doesn't end with a line break.
+func (w *lineWriter) flush() {
+	if w.buf.Len() > 0 {
+		w.logLine(w.buf.String())
+		w.buf.Reset()
+	}
+}
+
+func (w *lineWriter) logLine(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	if w.source == "stderr" {
+		w.logger.write(w.logger.writer, "warn", line, w.source)
+	} else if w.logger.level >= log.INFO {
+		w.logger.write(w.logger.writer, "info", line, w.source)
+	}
+}
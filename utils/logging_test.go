@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jfrog/jfrog-client-go/utils/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJsonLogging(t *testing.T) {
+	var buf bytes.Buffer
+	commandLogger = newJsonLogger(log.WARN, &buf)
+	defer func() {
+		commandLogger = nil
+		log.SetLogger(log.NewLogger(log.INFO, nil))
+		SetLogContext("", "", "")
+	}()
+	log.SetLogger(commandLogger)
+
+	SetLogContext("my-project", "master", "abc")
+	SetLogStage(BuildStage)
+	log.Info("filtered")
+	log.Warn("Retrying the build")
+	assert.NoError(t, RunCmd("", "echo line1; echo line2 >&2; printf last"))
+
+	records := readLogRecords(t, &buf)
+	for _, record := range records {
+		assert.Equal(t, "my-project", record.Project)
+		assert.Equal(t, "master", record.Branch)
+		assert.Equal(t, "abc", record.Commit)
+		assert.Equal(t, BuildStage, record.Stage)
+		assert.Equal(t, "warn", record.Level)
+	}
+	// The standard error of a command is logged as warnings.
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "Retrying the build", records[0].Message)
+		assert.Equal(t, agentLogSource, records[0].Source)
+		assert.Equal(t, "line2", records[1].Message)
+		assert.Equal(t, "stderr", records[1].Source)
+	}
+
+	// The command output is logged line by line, with its stream as the source.
+	commandLogger.SetLogLevel(log.INFO)
+	assert.NoError(t, RunCmd("", "echo line1; echo line2 >&2; printf last"))
+	records = readLogRecords(t, &buf)
+	sources := map[string][]string{}
+	for _, record := range records {
+		sources[record.Source] = append(sources[record.Source], record.Message)
+	}
+	assert.Equal(t, map[string][]string{"stdout": {"line1", "last"}, "stderr": {"line2"}}, sources)
+
+	// The cause of a failed build is logged at the 'ERROR' level.
+	commandLogger.SetLogLevel(log.ERROR)
+	assert.Error(t, RunCmd("", "echo building; echo 'npm ERR! missing script: build' >&2; exit 1"))
+	records = readLogRecords(t, &buf)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "warn", records[0].Level)
+		assert.Equal(t, "npm ERR! missing script: build", records[0].Message)
+		assert.Equal(t, "stderr", records[0].Source)
+	}
+}
+
+func TestConfigureLogging(t *testing.T) {
+	defer func() {
+		assert.NoError(t, ConfigureLogging(nil))
+	}()
+	assert.NoError(t, ConfigureLogging(&Logging{Format: JsonLogFormat, Level: "debug"}))
+	assert.Equal(t, log.DEBUG, log.GetLogLevel())
+	assert.NotNil(t, commandLogger)
+	assert.NoError(t, ConfigureLogging(&Logging{Level: "ERROR"}))
+	assert.Equal(t, log.ERROR, log.GetLogLevel())
+	assert.Nil(t, commandLogger)
+	assert.Error(t, ConfigureLogging(&Logging{Format: "xml"}))
+	assert.Error(t, ConfigureLogging(&Logging{Level: "verbose"}))
+}
+
+// Reads and resets the log records written to buf.
+func readLogRecords(t *testing.T, buf *bytes.Buffer) []logRecord {
+	var records []logRecord
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record logRecord
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	buf.Reset()
+	return records
+}
@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jfrog/jfrog-client-go/utils/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	metricsNamespace = "vcs_agent"
+	metricsPath      = "/metrics"
+	// The job of the metrics pushed to a Pushgateway.
+	metricsJob = "vcs_agent"
+	// The label of the branch of a metric, and of its group in a Pushgateway.
+	branchLabel = "branch"
+)
+
+// The stages of a commit scan, set in the log context. The build, publish and scan stages are timed by BranchMetrics.TimeStage.
+const (
+	CheckoutStage = "checkout"
+	BuildStage    = "build"
+	BagStage      = "bag"
+	PublishStage  = "publish"
+	ScanStage     = "scan"
+)
+
+// The reasons of a commit which failed to build, counted by 'build_failures_total'.
+const (
+	CheckoutFailure   = "checkout"
+	CleanFailure      = "clean"
+	SubmodulesFailure = "submodules"
+	LfsFailure        = "lfs"
+	BuildFailure      = "build"
+	BagFailure        = "bag"
+)
+
+// The metrics configuration.
+// The agent exits at the end of each run, so the metrics count the events of a single run, and the endpoint is served only during the run.
+// To keep the metrics between runs, push them to a Prometheus Pushgateway, which exposes the metrics of the last scan of each branch.
+type Metrics struct {
+	// The address of the '/metrics' endpoint, such as ':9090'. Metrics aren't served when empty.
+	Address string `yaml:"address"`
+	// The URL of a Prometheus Pushgateway, such as 'http://pushgateway:9091'. The metrics are pushed when the agent exits, under the
+	// 'vcs_agent' job, an 'instance' label of the project name and a 'branch' label. The metrics of each scanned branch replace
+	// the metrics of its previous run, and the metrics of the other branches are kept. Metrics aren't pushed in a dry run.
+	PushGateway string `yaml:"pushGateway"`
+}
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+	commitsScanned  = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "commits_scanned_total",
+		Help:      "The number of commits which were built, published and scanned.",
+	}, []string{"project", "branch"})
+	buildFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "build_failures_total",
+		Help:      "The number of commits which failed to build, by reason.",
+	}, []string{"project", "branch", "reason"})
+	publishErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "publish_errors_total",
+		Help:      "The number of builds which failed to publish.",
+	}, []string{"project", "branch"})
+	scanErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "scan_errors_total",
+		Help:      "The number of builds which failed to scan. Builds in which Xray found violations are not errors.",
+	}, []string{"project", "branch"})
+	stageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "stage_duration_seconds",
+		Help:      "The duration of the build, publish and scan stages of a commit.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"project", "branch", "stage"})
+	branchScanDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "branch_scan_duration_seconds",
+		Help:      "The duration of scanning the new commits of a branch.",
+		Buckets:   []float64{1, 10, 60, 300, 600, 1800, 3600, 7200, 14400},
+	}, []string{"project", "branch"})
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_depth",
+		Help:      "The number of commits of a branch which are waiting to be scanned.",
+	}, []string{"project", "branch"})
+	lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "The Unix time of the last successful scan of a branch.",
+	}, []string{"project", "branch"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(commitsScanned, buildFailures, publishErrors, scanErrors, stageDuration, branchScanDuration, queueDepth, lastSuccess)
+}
+
+// Serve the metrics on the '/metrics' endpoint of 'metrics.Address', in the background.
+// Returns the listener of the endpoint, to be closed when the agent exits, or nil if metrics are disabled.
+func ServeMetrics(metrics *Metrics) (net.Listener, error) {
+	if metrics == nil || metrics.Address == "" {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", metrics.Address)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	go func() {
+		// Serve returns an error once the listener is closed.
+		_ = http.Serve(listener, mux)
+	}()
+	log.Info("Serving metrics on '" + listener.Addr().String() + metricsPath + "'")
+	return listener, nil
+}
+
+// Push the metrics of the run to the Pushgateway of 'metrics.PushGateway', if it is set.
+// The metrics of each branch are pushed to a group of the branch, so that a run replaces only the metrics of the branches it scanned.
+func PushMetrics(metrics *Metrics, project string) error {
+	if metrics == nil || metrics.PushGateway == "" {
+		return nil
+	}
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		return err
+	}
+	branchFamilies := splitByBranch(families)
+	var branches []string
+	for branch := range branchFamilies {
+		branches = append(branches, branch)
+	}
+	sort.Strings(branches)
+	for _, branch := range branches {
+		gatherer := gathererOf(branchFamilies[branch])
+		pusher := push.New(metrics.PushGateway, metricsJob).Gatherer(gatherer).Grouping("instance", project).Grouping(branchLabel, branch).Client(httpClient)
+		if err := Retry("Pushing the metrics of '"+branch+"' to '"+metrics.PushGateway+"'", pusher.Push); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Splits the metric families by the branch label of their metrics. The label is removed, as the Pushgateway rejects
+// metrics which have a label of their group.
+func splitByBranch(families []*dto.MetricFamily) map[string][]*dto.MetricFamily {
+	branchFamilies := make(map[string][]*dto.MetricFamily)
+	for _, family := range families {
+		byBranch := make(map[string]*dto.MetricFamily)
+		for _, metric := range family.Metric {
+			var branch string
+			var labels []*dto.LabelPair
+			for _, label := range metric.Label {
+				if label.GetName() == branchLabel {
+					branch = label.GetValue()
+				} else {
+					labels = append(labels, label)
+				}
+			}
+			metric.Label = labels
+			branchFamily, ok := byBranch[branch]
+			if !ok {
+				branchFamily = &dto.MetricFamily{Name: family.Name, Help: family.Help, Type: family.Type}
+				byBranch[branch] = branchFamily
+				branchFamilies[branch] = append(branchFamilies[branch], branchFamily)
+			}
+			branchFamily.Metric = append(branchFamily.Metric, metric)
+		}
+	}
+	return branchFamilies
+}
+
+func gathererOf(families []*dto.MetricFamily) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return families, nil
+	})
+}
+
+// Records the metrics of a branch scan. A tag scan is recorded under the branch 'tags/<tag>'.
+type BranchMetrics struct {
+	project string
+	branch  string
+	started time.Time
+}
+
+func NewBranchMetrics(project, branch string) *BranchMetrics {
+	return &BranchMetrics{project: project, branch: branch, started: time.Now()}
+}
+
+// Time a stage of a commit scan. Returns the error of 'stage'.
+func (m *BranchMetrics) TimeStage(stage string, run func() error) error {
+	started := time.Now()
+	err := run()
+	stageDuration.WithLabelValues(m.project, m.branch, stage).Observe(time.Since(started).Seconds())
+	return err
+}
+
+func (m *BranchMetrics) SetQueueDepth(depth int) {
+	queueDepth.WithLabelValues(m.project, m.branch).Set(float64(depth))
+}
+
+// A commit was built, published and scanned. Violations found by Xray don't fail the scan.
+func (m *BranchMetrics) CommitScanned() {
+	commitsScanned.WithLabelValues(m.project, m.branch).Inc()
+}
+
+func (m *BranchMetrics) BuildFailed(reason string) {
+	buildFailures.WithLabelValues(m.project, m.branch, reason).Inc()
+}
+
+func (m *BranchMetrics) PublishFailed() {
+	publishErrors.WithLabelValues(m.project, m.branch).Inc()
+}
+
+func (m *BranchMetrics) ScanFailed() {
+	scanErrors.WithLabelValues(m.project, m.branch).Inc()
+}
+
+// All the new commits of the branch were handled.
+func (m *BranchMetrics) BranchScanned() {
+	branchScanDuration.WithLabelValues(m.project, m.branch).Observe(time.Since(m.started).Seconds())
+	lastSuccess.WithLabelValues(m.project, m.branch).SetToCurrentTime()
+	m.SetQueueDepth(0)
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMetrics(t *testing.T) {
+	listener, err := ServeMetrics(&Metrics{Address: "127.0.0.1:0"})
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	metrics := NewBranchMetrics("my-project", "master")
+	metrics.SetQueueDepth(2)
+	assert.NoError(t, metrics.TimeStage(BuildStage, func() error { return nil }))
+	metrics.CommitScanned()
+	assert.EqualError(t, metrics.TimeStage(PublishStage, func() error { return errors.New("publish failed") }), "publish failed")
+	metrics.PublishFailed()
+	metrics.BuildFailed(BuildFailure)
+	metrics.BranchScanned()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	for _, expected := range []string{
+		`vcs_agent_commits_scanned_total{branch="master",project="my-project"} 1`,
+		`vcs_agent_build_failures_total{branch="master",project="my-project",reason="build"} 1`,
+		`vcs_agent_publish_errors_total{branch="master",project="my-project"} 1`,
+		`vcs_agent_stage_duration_seconds_count{branch="master",project="my-project",stage="build"} 1`,
+		`vcs_agent_stage_duration_seconds_count{branch="master",project="my-project",stage="publish"} 1`,
+		`vcs_agent_branch_scan_duration_seconds_count{branch="master",project="my-project"} 1`,
+		`vcs_agent_queue_depth{branch="master",project="my-project"} 0`,
+		`vcs_agent_last_success_timestamp_seconds{branch="master",project="my-project"}`,
+	} {
+		assert.Contains(t, string(body), expected)
+	}
+
+	listener, err = ServeMetrics(&Metrics{})
+	assert.NoError(t, err)
+	assert.Nil(t, listener)
+}
+
+func TestPushMetrics(t *testing.T) {
+	setupRetryTest(t, &RetryPolicy{MaxAttempts: 1})
+	pushes := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		grouping := parseGrouping(r.URL.Path)
+		assert.Equal(t, "vcs_agent", grouping["job"])
+		assert.Equal(t, "my-project", grouping["instance"])
+		pushes[grouping["branch"]] = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// The metrics registry is shared by the tests, so the branches are used only by this test.
+	NewBranchMetrics("my-project", "push").CommitScanned()
+	NewBranchMetrics("my-project", "release/push").BuildFailed(BuildFailure)
+	assert.NoError(t, PushMetrics(&Metrics{PushGateway: server.URL}, "my-project"))
+	// Each branch is pushed to its own group. The push fails if a metric still has the branch label.
+	scanned := pushes["push"]
+	assert.Contains(t, scanned, "vcs_agent_commits_scanned_total")
+	assert.NotContains(t, scanned, "vcs_agent_build_failures_total")
+	failed := pushes["release/push"]
+	assert.Contains(t, failed, "vcs_agent_build_failures_total")
+	assert.NotContains(t, failed, "vcs_agent_commits_scanned_total")
+
+	// Metrics aren't pushed without a Pushgateway.
+	pushed := len(pushes)
+	assert.NoError(t, PushMetrics(&Metrics{}, "my-project"))
+	assert.NoError(t, PushMetrics(nil, "my-project"))
+	assert.Len(t, pushes, pushed)
+}
+
+// Maps the labels of a Pushgateway group to their values, decoding base64 encoded values.
+func parseGrouping(path string) map[string]string {
+	segments := strings.Split(strings.TrimPrefix(path, "/metrics/"), "/")
+	grouping := make(map[string]string)
+	for i := 0; i+1 < len(segments); i += 2 {
+		name, value := segments[i], segments[i+1]
+		if strings.HasSuffix(name, "@base64") {
+			decoded, err := base64.RawURLEncoding.DecodeString(value)
+			if err == nil {
+				name, value = strings.TrimSuffix(name, "@base64"), string(decoded)
+			}
+		}
+		grouping[name] = value
+	}
+	return grouping
+}
@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	gitclient "github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+const (
+	// JFrog CLI home directory environment variable. Defaults to '~/.jfrog'.
+	jfrogCliHomeDir = "JFROG_CLI_HOME_DIR"
+	// The name of the CA bundle, copied into the JFrog CLI certificates directory.
+	caBundleFileName = "vcs-agent-ca-bundle.pem"
+)
+
+// Network settings, applied to the git, Artifactory and JFrog CLI traffic.
+type Network struct {
+	// Proxy URL, such as 'http://proxy.company.com:8080'.
+	Proxy string `yaml:"proxy"`
+	// Path to a PEM file with root CAs to trust, in addition to the system root CAs.
+	CaBundle string `yaml:"caBundle"`
+	// Skip TLS certificates verification. Insecure, for testing only.
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+}
+
+// The HTTP client used by the agent for requests which are not sent by go-git or jfrog-client-go.
+var httpClient = http.DefaultClient
+
+// The system root CA files, as searched by the crypto/x509 package on Linux. 'SSL_CERT_FILE' overrides them.
+var systemCaFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// The files written by ConfigureNetwork, which are removed by CleanupNetwork.
+var networkFiles []string
+
+// Apply the network settings:
+// 1. The git HTTP transport and the agent HTTP client use the proxy and the CA bundle.
+// 2. The proxy environment variables are set, for jfrog-client-go and the JFrog CLI and git subprocesses.
+// 3. The git TLS environment variables are set. The git binary trusts the system root CAs and the CAs of the bundle.
+// 4. The CA bundle is copied into the JFrog CLI certificates directory.
+// Returns the certificates directory, or an empty string if there is no CA bundle.
+// The files written for git and the JFrog CLI are removed by CleanupNetwork.
+func ConfigureNetwork(n *Network) (string, error) {
+	if n == nil {
+		return "", nil
+	}
+	if n.InsecureSkipVerify {
+		log.Warn("TLS CERTIFICATES VERIFICATION IS DISABLED! The git, Artifactory and JFrog CLI traffic is exposed to man-in-the-middle attacks. Set 'insecureSkipVerify' for testing only.")
+	}
+	client, err := newHttpClient(n)
+	if err != nil {
+		return "", err
+	}
+	httpClient = client
+	gitclient.InstallProtocol("https", githttp.NewClient(client))
+	gitclient.InstallProtocol("http", githttp.NewClient(client))
+	if n.Proxy != "" {
+		for _, env := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+			if err = os.Setenv(env, n.Proxy); err != nil {
+				return "", err
+			}
+		}
+	}
+	if n.InsecureSkipVerify {
+		if err = os.Setenv("GIT_SSL_NO_VERIFY", "true"); err != nil {
+			return "", err
+		}
+	}
+	if n.CaBundle == "" {
+		return "", nil
+	}
+	gitCaBundle, err := writeGitCaBundle(n.CaBundle)
+	if err != nil {
+		return "", err
+	}
+	if err = os.Setenv("GIT_SSL_CAINFO", gitCaBundle); err != nil {
+		return "", err
+	}
+	return installCaBundle(n.CaBundle)
+}
+
+// Remove the files written by ConfigureNetwork.
+func CleanupNetwork() {
+	for _, file := range networkFiles {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Error(err.Error())
+		}
+	}
+	networkFiles = nil
+}
+
+func newHttpClient(n *Network) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if n.Proxy != "" {
+		proxyUrl, err := url.Parse(n.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL '%s': %s", n.Proxy, err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: n.InsecureSkipVerify}
+	if n.CaBundle != "" {
+		rootCAs, err := loadRootCAs(n.CaBundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.RootCAs = rootCAs
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// Returns the system root CAs, with the CAs of the bundle.
+func loadRootCAs(caBundle string) (*x509.CertPool, error) {
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil || rootCAs == nil {
+		rootCAs = x509.NewCertPool()
+	}
+	data, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return nil, err
+	}
+	if !rootCAs.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in the CA bundle '%s'", caBundle)
+	}
+	return rootCAs, nil
+}
+
+// Write the system root CAs and the CAs of the bundle into a temporary file, for the git binary.
+// 'GIT_SSL_CAINFO' replaces the CAs which git trusts by default, therefore the system root CAs are added to the bundle.
+// Returns the absolute path of the file, since git runs in the project directory.
+func writeGitCaBundle(caBundle string) (string, error) {
+	data, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return "", err
+	}
+	systemCas, err := readSystemCas()
+	if err != nil {
+		return "", err
+	}
+	if systemCas == nil {
+		log.Warn("The system root CAs weren't found. The git binary trusts only the CAs of '" + caBundle + "'")
+	}
+	file, err := ioutil.TempFile("", "vcs-agent-git-ca-*.pem")
+	if err != nil {
+		return "", err
+	}
+	networkFiles = append(networkFiles, file.Name())
+	_, err = file.Write(append(append(systemCas, '\n'), data...))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(file.Name())
+}
+
+// Returns the content of the first system root CA file found, or nil if there is none.
+func readSystemCas() ([]byte, error) {
+	files := systemCaFiles
+	if fromEnv := os.Getenv("SSL_CERT_FILE"); fromEnv != "" {
+		files = []string{fromEnv}
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err == nil {
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// Copy the CA bundle into the JFrog CLI certificates directory, which is trusted by the JFrog CLI and jfrog-client-go.
+func installCaBundle(caBundle string) (string, error) {
+	certsDir, err := getJfrogCliCertsDir()
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(certsDir, 0700); err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return "", err
+	}
+	installed := filepath.Join(certsDir, caBundleFileName)
+	networkFiles = append(networkFiles, installed)
+	return certsDir, ioutil.WriteFile(installed, data, 0600)
+}
+
+func getJfrogCliCertsDir() (string, error) {
+	homeDir := os.Getenv(jfrogCliHomeDir)
+	if homeDir == "" {
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		homeDir = filepath.Join(userHome, ".jfrog")
+	}
+	return filepath.Join(homeDir, "security", "certs"), nil
+}
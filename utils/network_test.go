@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHttpClientCaBundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caBundle := writeCaBundle(t, server)
+
+	client, err := newHttpClient(&Network{})
+	assert.NoError(t, err)
+	_, err = client.Get(server.URL)
+	assert.Error(t, err)
+
+	client, err = newHttpClient(&Network{CaBundle: caBundle})
+	assert.NoError(t, err)
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+
+	client, err = newHttpClient(&Network{InsecureSkipVerify: true})
+	assert.NoError(t, err)
+	resp, err = client.Get(server.URL)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+
+	_, err = newHttpClient(&Network{CaBundle: filepath.Join(filepath.Dir(caBundle), "missing.pem")})
+	assert.Error(t, err)
+}
+
+func TestNewHttpClientProxy(t *testing.T) {
+	var requestedUrl string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedUrl = r.URL.String()
+	}))
+	defer proxy.Close()
+	client, err := newHttpClient(&Network{Proxy: proxy.URL})
+	assert.NoError(t, err)
+	resp, err := client.Get("http://git.company.internal/project.git/info/refs")
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, "http://git.company.internal/project.git/info/refs", requestedUrl)
+
+	_, err = newHttpClient(&Network{Proxy: "http://proxy:port"})
+	assert.Error(t, err)
+}
+
+func TestInstallCaBundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caBundle := writeCaBundle(t, server)
+	homeDir, cleanup := createTempDir(t)
+	defer cleanup()
+	assert.NoError(t, os.Setenv(jfrogCliHomeDir, homeDir))
+	defer func() { assert.NoError(t, os.Unsetenv(jfrogCliHomeDir)) }()
+
+	certsDir, err := installCaBundle(caBundle)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(homeDir, "security", "certs"), certsDir)
+	expected, err := ioutil.ReadFile(caBundle)
+	assert.NoError(t, err)
+	assertFileContent(t, filepath.Join(certsDir, caBundleFileName), string(expected))
+
+	CleanupNetwork()
+	assert.NoFileExists(t, filepath.Join(certsDir, caBundleFileName))
+}
+
+func TestWriteGitCaBundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caBundle := writeCaBundle(t, server)
+	dir, cleanup := createTempDir(t)
+	defer cleanup()
+	systemCas := filepath.Join(dir, "ca-certificates.crt")
+	assert.NoError(t, ioutil.WriteFile(systemCas, []byte("# The system root CAs\n"), 0600))
+	oldSystemCaFiles := systemCaFiles
+	defer func() { systemCaFiles = oldSystemCaFiles }()
+	systemCaFiles = []string{filepath.Join(dir, "missing.pem"), systemCas}
+	if fromEnv, ok := os.LookupEnv("SSL_CERT_FILE"); ok {
+		defer func() { assert.NoError(t, os.Setenv("SSL_CERT_FILE", fromEnv)) }()
+		assert.NoError(t, os.Unsetenv("SSL_CERT_FILE"))
+	}
+
+	// git trusts both the system root CAs and the CAs of the bundle.
+	gitCaBundle, err := writeGitCaBundle(caBundle)
+	assert.NoError(t, err)
+	assert.True(t, filepath.IsAbs(gitCaBundle))
+	custom, err := ioutil.ReadFile(caBundle)
+	assert.NoError(t, err)
+	assertFileContent(t, gitCaBundle, "# The system root CAs\n\n"+string(custom))
+
+	CleanupNetwork()
+	assert.NoFileExists(t, gitCaBundle)
+}
+
+// Write the certificate of a TLS test server to a PEM file.
+func writeCaBundle(t *testing.T, server *httptest.Server) string {
+	dir, cleanup := createTempDir(t)
+	t.Cleanup(cleanup)
+	caBundle := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(caBundle, data, 0600))
+	return caBundle
+}
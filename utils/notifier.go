@@ -0,0 +1,292 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+const (
+	SlackNotification   = "slack"
+	TeamsNotification   = "teams"
+	WebhookNotification = "webhook"
+
+	defaultMaxFindings = 5
+)
+
+// Returns the current time. Overridden in tests.
+var timeNow = time.Now
+
+// A chat or webhook notification on violations found by Xray.
+type Notification struct {
+	// One of 'slack', 'teams' (Microsoft Teams) or 'webhook', which posts the violations as JSON.
+	Type string `yaml:"type"`
+	// The incoming webhook URL.
+	Url string `yaml:"url"`
+	// Branch names or patterns to notify about. Notifies about all the branches when empty.
+	Branches []string `yaml:"branches"`
+	// The minimum time between notifications about a branch, such as '1h'. The notifications in between are skipped, and counted in the next one.
+	// The last skipped notification about a branch is sent at the end of the run, so the latest violations are always notified about.
+	// The last notification time isn't stored, so the interval applies within a single run of the agent only.
+	// The first notification about a branch in each run is always sent.
+	MinInterval time.Duration `yaml:"minInterval"`
+	// The number of findings in a message. Defaults to 5.
+	MaxFindings int `yaml:"maxFindings"`
+	// Only notify about violations which are new since the previous scanned commit of the branch.
+	// The scan results are compared if 'jfrog.scanResultsRepo' is set. Otherwise, all the violations are notified about.
+	NewFindingsOnly bool `yaml:"newFindingsOnly"`
+}
+
+// Violations which Xray found in the build of a commit.
+type ViolationsEvent struct {
+	Project     string
+	Branch      string
+	BuildName   string
+	BuildNumber string
+	Commit      string
+	Author      string
+	Result      *ScanResult
+	// The findings compared to the previous scanned commit of the branch. Nil if the scan results aren't compared.
+	Diff ScanDiff
+}
+
+// Returns the findings to notify about, from the highest to the lowest severity.
+// The fixed findings of compared scan results are excluded, and with newOnly, the unchanged findings too.
+func (e *ViolationsEvent) findings(newOnly bool) ScanDiff {
+	if e.Diff == nil {
+		var findings ScanDiff
+		for _, issue := range e.Result.Issues() {
+			findings = append(findings, ScanFinding{ScanIssue: issue})
+		}
+		return findings
+	}
+	if newOnly {
+		return e.Diff.Filter(NewFinding)
+	}
+	return e.Diff.Filter(NewFinding, UnchangedFinding)
+}
+
+// Sends the configured notifications.
+type Notifiers struct {
+	notifiers []*notifier
+}
+
+type notifier struct {
+	config Notification
+	// Creates the JSON message of a notification, from the top findings, the total number of findings and the number of skipped notifications.
+	createMessage func(event *ViolationsEvent, findings ScanDiff, total, skipped int) interface{}
+	// The last notification time, the number of skipped notifications and the last skipped notification of each branch, in the current run.
+	lastSent map[string]time.Time
+	skipped  map[string]int
+	pending  map[string]*ViolationsEvent
+}
+
+func NewNotifiers(configs []Notification) (*Notifiers, error) {
+	notifiers := new(Notifiers)
+	for _, config := range configs {
+		n := &notifier{config: config, lastSent: map[string]time.Time{}, skipped: map[string]int{}, pending: map[string]*ViolationsEvent{}}
+		switch config.Type {
+		case SlackNotification:
+			n.createMessage = createSlackMessage
+		case TeamsNotification:
+			n.createMessage = createTeamsMessage
+		case WebhookNotification:
+			n.createMessage = createWebhookMessage
+		default:
+			return nil, fmt.Errorf("unknown notification type '%s', expecting one of: %s, %s, %s", config.Type, SlackNotification, TeamsNotification, WebhookNotification)
+		}
+		if config.Url == "" {
+			return nil, fmt.Errorf("the url of the '%s' notification is missing", config.Type)
+		}
+		if n.config.MaxFindings <= 0 {
+			n.config.MaxFindings = defaultMaxFindings
+		}
+		notifiers.notifiers = append(notifiers.notifiers, n)
+	}
+	return notifiers, nil
+}
+
+// Notify about violations found in a branch. A failed notification is logged and doesn't fail the scan.
+func (n *Notifiers) NotifyViolations(event *ViolationsEvent) {
+	if n == nil {
+		return
+	}
+	for _, notifier := range n.notifiers {
+		if err := notifier.notify(event); err != nil {
+			log.Error("Failed to send the " + notifier.config.Type + " notification: " + err.Error())
+		}
+	}
+}
+
+// Send the last skipped notification about each branch, which would otherwise be lost when the agent exits.
+// The notifications skipped before it are counted in it. Called at the end of the run.
+func (n *Notifiers) SendSkipped() {
+	if n == nil {
+		return
+	}
+	for _, notifier := range n.notifiers {
+		var branches []string
+		for branch := range notifier.pending {
+			branches = append(branches, branch)
+		}
+		sort.Strings(branches)
+		for _, branch := range branches {
+			log.Info("Sending the last skipped " + notifier.config.Type + " notification of branch '" + branch + "'")
+			if err := notifier.send(notifier.pending[branch], notifier.skipped[branch]-1); err != nil {
+				log.Error("Failed to send the " + notifier.config.Type + " notification: " + err.Error())
+			}
+			delete(notifier.pending, branch)
+			notifier.skipped[branch] = 0
+		}
+	}
+}
+
+func (n *notifier) notify(event *ViolationsEvent) error {
+	if len(n.config.Branches) > 0 && !matchAnyName(n.config.Branches, event.Branch) {
+		return nil
+	}
+	findings := event.findings(n.config.NewFindingsOnly)
+	if len(findings) == 0 && event.Diff != nil {
+		log.Info("No new violations in branch '" + event.Branch + "', skipping the " + n.config.Type + " notification")
+		return nil
+	}
+	now := timeNow()
+	if last, ok := n.lastSent[event.Branch]; ok && now.Sub(last) < n.config.MinInterval {
+		n.skipped[event.Branch]++
+		n.pending[event.Branch] = event
+		log.Info("Skipping the " + n.config.Type + " notification of branch '" + event.Branch + "', sent less than " + n.config.MinInterval.String() + " ago")
+		return nil
+	}
+	if err := n.send(event, n.skipped[event.Branch]); err != nil {
+		return err
+	}
+	n.lastSent[event.Branch] = now
+	n.skipped[event.Branch] = 0
+	delete(n.pending, event.Branch)
+	return nil
+}
+
+func (n *notifier) send(event *ViolationsEvent, skipped int) error {
+	findings := event.findings(n.config.NewFindingsOnly)
+	total := len(findings)
+	if total > n.config.MaxFindings {
+		findings = findings[:n.config.MaxFindings]
+	}
+	body, err := json.Marshal(n.createMessage(event, findings, total, skipped))
+	if err != nil {
+		return err
+	}
+	return Retry("Sending the "+n.config.Type+" notification", func() error {
+		return postJson(n.config.Url, body)
+	})
+}
+
+func postJson(url string, body []byte) error {
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification request failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// Returns the title and the lines of a notification, formatted with markdown.
+func formatViolations(event *ViolationsEvent, findings ScanDiff, total, skipped int) (string, []string) {
+	title := fmt.Sprintf("Xray found violations in %s, branch '%s'", event.Project, event.Branch)
+	lines := []string{fmt.Sprintf("Commit `%s` by %s, build %s/%s", ToShortCommitHash(event.Commit), event.Author, event.BuildName, event.BuildNumber)}
+	if event.Diff != nil {
+		lines = append(lines, fmt.Sprintf("%d new, %d unchanged and %d fixed violations since the previous scanned commit.",
+			len(event.Diff.Filter(NewFinding)), len(event.Diff.Filter(UnchangedFinding)), len(event.Diff.Filter(FixedFinding))))
+	}
+	for _, finding := range findings {
+		line := "• " + formatFinding(finding.ScanIssue)
+		if finding.Status == NewFinding {
+			line += " (new)"
+		}
+		lines = append(lines, line)
+	}
+	if total > len(findings) {
+		lines = append(lines, fmt.Sprintf("And %d more violations.", total-len(findings)))
+	}
+	if event.Result != nil && event.Result.Summary.MoreDetailsUrl != "" {
+		lines = append(lines, "Details: "+event.Result.Summary.MoreDetailsUrl)
+	}
+	if skipped > 0 {
+		lines = append(lines, fmt.Sprintf("%d notifications about this branch were skipped since the last one.", skipped))
+	}
+	return title, lines
+}
+
+func formatFinding(finding ScanIssue) string {
+	text := "[" + finding.Severity + "] "
+	if finding.Cve != "" {
+		text += finding.Cve + " "
+	}
+	return text + finding.Summary
+}
+
+func createSlackMessage(event *ViolationsEvent, findings ScanDiff, total, skipped int) interface{} {
+	title, lines := formatViolations(event, findings, total, skipped)
+	return map[string]string{"text": "*" + title + "*\n" + strings.Join(lines, "\n")}
+}
+
+// A Microsoft Teams message card.
+func createTeamsMessage(event *ViolationsEvent, findings ScanDiff, total, skipped int) interface{} {
+	title, lines := formatViolations(event, findings, total, skipped)
+	return map[string]string{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  title,
+		"title":    title,
+		// Teams markdown requires a blank line between paragraphs.
+		"text": strings.Join(lines, "\n\n"),
+	}
+}
+
+// The JSON body of the 'webhook' notification.
+type webhookMessage struct {
+	Project         string   `json:"project"`
+	Branch          string   `json:"branch"`
+	BuildName       string   `json:"buildName"`
+	BuildNumber     string   `json:"buildNumber"`
+	Commit          string   `json:"commit"`
+	Author          string   `json:"author"`
+	TotalViolations int      `json:"totalViolations"`
+	Findings        ScanDiff `json:"findings"`
+	// The number of findings by status, if the scan results are compared to the previous scanned commit.
+	Changes        map[FindingStatus]int `json:"changes,omitempty"`
+	MoreDetailsUrl string                `json:"moreDetailsUrl,omitempty"`
+	Skipped        int                   `json:"skipped"`
+}
+
+func createWebhookMessage(event *ViolationsEvent, findings ScanDiff, total, skipped int) interface{} {
+	message := &webhookMessage{
+		Project:         event.Project,
+		Branch:          event.Branch,
+		BuildName:       event.BuildName,
+		BuildNumber:     event.BuildNumber,
+		Commit:          event.Commit,
+		Author:          event.Author,
+		TotalViolations: len(event.Result.Issues()),
+		Findings:        findings,
+		Skipped:         skipped,
+	}
+	if event.Diff != nil {
+		message.Changes = map[FindingStatus]int{}
+		for _, finding := range event.Diff {
+			message.Changes[finding.Status]++
+		}
+	}
+	if event.Result != nil {
+		message.MoreDetailsUrl = event.Result.Summary.MoreDetailsUrl
+	}
+	return message
+}
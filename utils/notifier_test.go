@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyViolations(t *testing.T) {
+	setupRetryTest(t, &RetryPolicy{MaxAttempts: 1})
+	bodies := map[string][]map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies[r.URL.Path] = append(bodies[r.URL.Path], body)
+	}))
+	defer server.Close()
+	now := time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	notifiers, err := NewNotifiers([]Notification{
+		{Type: SlackNotification, Url: server.URL + "/slack", Branches: []string{"main"}, MinInterval: time.Hour, MaxFindings: 1},
+		{Type: TeamsNotification, Url: server.URL + "/teams", Branches: []string{"main"}},
+		{Type: WebhookNotification, Url: server.URL + "/webhook"},
+	})
+	assert.NoError(t, err)
+	event := &ViolationsEvent{Project: "my-project", Branch: "main", BuildName: "my-build", BuildNumber: "3", Commit: "0123456789abcdef", Author: "dev <dev@jfrog.com>", Result: createScanResult(testViolations...)}
+	notifiers.NotifyViolations(event)
+	if assert.Len(t, bodies["/slack"], 1) {
+		assert.Equal(t, "*Xray found violations in my-project, branch 'main'*\n"+
+			"Commit `01234567` by dev <dev@jfrog.com>, build my-build/3\n"+
+			"• [Critical] CVE-2021-0002 Remote code execution\n"+
+			"And 1 more violations.", bodies["/slack"][0]["text"])
+	}
+	if assert.Len(t, bodies["/teams"], 1) {
+		assert.Equal(t, "MessageCard", bodies["/teams"][0]["@type"])
+	}
+	if assert.Len(t, bodies["/webhook"], 1) {
+		assert.Equal(t, "0123456789abcdef", bodies["/webhook"][0]["commit"])
+		assert.Equal(t, float64(2), bodies["/webhook"][0]["totalViolations"])
+		assert.Len(t, bodies["/webhook"][0]["findings"], 2)
+	}
+
+	// Feature branches are only sent to the webhook.
+	notifiers.NotifyViolations(&ViolationsEvent{Project: "my-project", Branch: "feature/foo", Commit: "0123456789abcdef", Result: createScanResult(testViolations...)})
+	assert.Len(t, bodies["/slack"], 1)
+	assert.Len(t, bodies["/teams"], 1)
+	assert.Len(t, bodies["/webhook"], 2)
+
+	// Slack is rate limited. The skipped notifications are counted in the next one.
+	now = now.Add(30 * time.Minute)
+	notifiers.NotifyViolations(event)
+	assert.Len(t, bodies["/slack"], 1)
+	assert.Len(t, bodies["/teams"], 2)
+	now = now.Add(time.Hour)
+	notifiers.NotifyViolations(event)
+	if assert.Len(t, bodies["/slack"], 2) {
+		assert.Contains(t, bodies["/slack"][1]["text"], "1 notifications about this branch were skipped since the last one.")
+	}
+
+	// The last skipped notification is sent at the end of the run, counting the ones skipped before it.
+	now = now.Add(30 * time.Minute)
+	notifiers.NotifyViolations(event)
+	latest := *event
+	latest.BuildNumber = "5"
+	notifiers.NotifyViolations(&latest)
+	assert.Len(t, bodies["/slack"], 2)
+	notifiers.SendSkipped()
+	if assert.Len(t, bodies["/slack"], 3) {
+		assert.Contains(t, bodies["/slack"][2]["text"], "build my-build/5")
+		assert.Contains(t, bodies["/slack"][2]["text"], "1 notifications about this branch were skipped since the last one.")
+	}
+	notifiers.SendSkipped()
+	assert.Len(t, bodies["/slack"], 3)
+	assert.Len(t, bodies["/teams"], 5)
+
+	_, err = NewNotifiers([]Notification{{Type: "email", Url: server.URL}})
+	assert.Error(t, err)
+	_, err = NewNotifiers([]Notification{{Type: SlackNotification}})
+	assert.Error(t, err)
+}
+
+func TestNotifyNewViolations(t *testing.T) {
+	setupRetryTest(t, &RetryPolicy{MaxAttempts: 1})
+	bodies := map[string][]map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies[r.URL.Path] = append(bodies[r.URL.Path], body)
+	}))
+	defer server.Close()
+
+	notifiers, err := NewNotifiers([]Notification{
+		{Type: SlackNotification, Url: server.URL + "/slack", NewFindingsOnly: true},
+		{Type: WebhookNotification, Url: server.URL + "/webhook"},
+	})
+	assert.NoError(t, err)
+	result := createScanResult(testViolations...)
+	issues := result.Issues()
+	event := &ViolationsEvent{Project: "my-project", Branch: "main", BuildName: "my-build", BuildNumber: "3", Commit: "0123456789abcdef", Author: "dev <dev@jfrog.com>", Result: result,
+		Diff: ScanDiff{{issues[0], NewFinding}, {issues[1], UnchangedFinding}, {ScanIssue{Summary: "Fixed", Severity: "Low"}, FixedFinding}}}
+	notifiers.NotifyViolations(event)
+	if assert.Len(t, bodies["/slack"], 1) {
+		assert.Equal(t, "*Xray found violations in my-project, branch 'main'*\n"+
+			"Commit `01234567` by dev <dev@jfrog.com>, build my-build/3\n"+
+			"1 new, 1 unchanged and 1 fixed violations since the previous scanned commit.\n"+
+			"• [Critical] CVE-2021-0002 Remote code execution (new)", bodies["/slack"][0]["text"])
+	}
+	if assert.Len(t, bodies["/webhook"], 1) {
+		assert.Equal(t, map[string]interface{}{"new": float64(1), "unchanged": float64(1), "fixed": float64(1)}, bodies["/webhook"][0]["changes"])
+		if assert.Len(t, bodies["/webhook"][0]["findings"], 2) {
+			assert.Equal(t, "new", bodies["/webhook"][0]["findings"].([]interface{})[0].(map[string]interface{})["status"])
+		}
+	}
+
+	// Without new violations, only the notifications of all the violations are sent.
+	event.Diff = ScanDiff{{issues[0], UnchangedFinding}, {issues[1], UnchangedFinding}}
+	notifiers.NotifyViolations(event)
+	assert.Len(t, bodies["/slack"], 1)
+	assert.Len(t, bodies["/webhook"], 2)
+}
+
+func TestParseScanResult(t *testing.T) {
+	result, err := parseScanResult([]byte(`[Info] Scanning...` + "\n" + `{"summary":{"total_alerts":1,"fail_build":true,"more_details_url":"https://xray/details"},"alerts":[{"top_severity":"High","issues":[{"summary":"Denial of service","type":"security","severity":"Low"},{"summary":"Remote code execution","type":"security","severity":"High","cve":"CVE-2021-0001"}]}]}`))
+	assert.NoError(t, err)
+	assert.True(t, result.Summary.FailBuild)
+	assert.Equal(t, []ScanIssue{
+		{Summary: "Remote code execution", Type: "security", Severity: "High", Cve: "CVE-2021-0001"},
+		{Summary: "Denial of service", Type: "security", Severity: "Low"},
+	}, result.Issues())
+
+	result, err = parseScanResult(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+	_, err = parseScanResult([]byte("not json"))
+	assert.Error(t, err)
+}
+
+// The violations the notifications are tested with.
+var testViolations = []ScanIssue{
+	{Summary: "Denial of service", Severity: "Medium", Cve: "CVE-2021-0001"},
+	{Summary: "Remote code execution", Severity: "Critical", Cve: "CVE-2021-0002"},
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+// A single commit that the agent is about to build, publish and scan.
+// Either 'Branch' or 'Tag' is set.
+type PlanEntry struct {
+	Branch      string
+	Tag         string
+	Commit      string
+	BuildName   string
+	BuildNumber string
+}
+
+// The list of builds a run would produce. Used by the dry-run mode to review a config before publishing anything.
+type Plan []PlanEntry
+
+func (p Plan) String() string {
+	if len(p) == 0 {
+		return "No new commits to scan."
+	}
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REF\tCOMMIT\tBUILD NAME\tBUILD NUMBER")
+	for _, entry := range p {
+		ref := entry.Branch
+		if entry.Tag != "" {
+			ref = "tag:" + entry.Tag
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ref, entry.Commit, entry.BuildName, entry.BuildNumber)
+	}
+	w.Flush()
+	return fmt.Sprintf("%d builds would be published:\n%s", len(p), sb.String())
+}
@@ -0,0 +1,14 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanString(t *testing.T) {
+	assert.Equal(t, "No new commits to scan.", Plan{}.String())
+	plan := Plan{{Branch: "main", Commit: "abcdef1234", BuildName: "npm-example-main", BuildNumber: "5.0-abcdef12"}}
+	assert.Contains(t, plan.String(), "1 builds would be published:")
+	assert.Contains(t, plan.String(), "npm-example-main  5.0-abcdef12")
+}
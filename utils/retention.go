@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jfrog/jfrog-client-go/artifactory"
+	"github.com/jfrog/jfrog-client-go/artifactory/buildinfo"
+	"github.com/jfrog/jfrog-client-go/artifactory/services"
+	servicesutils "github.com/jfrog/jfrog-client-go/artifactory/services/utils"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+// The property of the build-info files of builds in which Xray found violations.
+const violationsProperty = "vcs-agent.violations"
+
+// Matches the build-info file names, '<build number>-<timestamp>.json'.
+var buildInfoFileNamePattern = regexp.MustCompile(`^(.+)-\d+\.json$`)
+
+// A retention policy for the builds of branches, applied after each publish.
+type Retention struct {
+	// Branch names or patterns the policy applies to. A branch uses the first policy which matches it.
+	Branches []string `yaml:"branches"`
+	// The number of latest builds to keep. 0 for no limit.
+	MaxBuilds int `yaml:"maxBuilds"`
+	// Discard builds which started more than this number of days ago. 0 for no limit.
+	MaxDays int `yaml:"maxDays"`
+	// Keep the builds in which Xray found violations.
+	KeepViolations bool `yaml:"keepViolations"`
+	// Delete the artifacts of the discarded builds.
+	DeleteArtifacts bool `yaml:"deleteArtifacts"`
+	// Log the builds which would be discarded, without discarding them.
+	DryRun bool `yaml:"dryRun"`
+}
+
+// Returns the retention policy of a branch, or nil if no policy matches it.
+func GetRetention(branch string, jfrog *JfrogDetails) *Retention {
+	for i, retention := range jfrog.Retention {
+		if matchAnyName(retention.Branches, branch) {
+			return &jfrog.Retention[i]
+		}
+	}
+	return nil
+}
+
+// Discard the builds of buildName which exceed the retention policy, using the discard builds API.
+func ApplyRetention(servicesManager artifactory.ArtifactoryServicesManager, buildName string, retention *Retention, jfrog *JfrogDetails) error {
+	if retention == nil || (retention.MaxBuilds <= 0 && retention.MaxDays <= 0) {
+		return nil
+	}
+	var keep []string
+	if retention.KeepViolations {
+		var err error
+		if keep, err = getViolationsBuildNumbers(servicesManager, buildName, jfrog); err != nil {
+			return err
+		}
+	}
+	if retention.DryRun {
+		builds, err := getBuilds(servicesManager, buildName, jfrog)
+		if err != nil {
+			return err
+		}
+		toDiscard := selectBuildsToDiscard(builds, retention, keep, time.Now())
+		log.Info(fmt.Sprintf("[Dry run] The retention policy would discard %d builds of '%s': %s", len(toDiscard), buildName, strings.Join(toDiscard, ", ")))
+		return nil
+	}
+	body := &services.DiscardBuildsBody{
+		ExcludeBuilds:   keep,
+		DeleteArtifacts: retention.DeleteArtifacts,
+	}
+	if retention.MaxBuilds > 0 {
+		body.MaxBuilds = strconv.Itoa(retention.MaxBuilds)
+	}
+	if retention.MaxDays > 0 {
+		body.MinimumBuildDate = time.Now().Add(-24 * time.Hour * time.Duration(retention.MaxDays)).Format(buildinfo.TimeFormat)
+	}
+	return Retry("Discarding the builds of '"+buildName+"'", func() error {
+		return discardBuilds(servicesManager, buildName, body, jfrog)
+	})
+}
+
+// Send a request to the discard builds API.
+// Unlike servicesManager.DiscardBuilds, the request selects the JFrog Project and the build-info repository of the builds, like the rest of the build API requests.
+func discardBuilds(servicesManager artifactory.ArtifactoryServicesManager, buildName string, body *services.DiscardBuildsBody, jfrog *JfrogDetails) error {
+	details := servicesManager.GetConfig().GetServiceDetails()
+	requestUrl, err := buildEscapedArtifactoryUrl(details.GetUrl(), "api/build/retention/"+url.PathEscape(buildName), getBuildApiParams(jfrog))
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	httpClientDetails := details.CreateHttpClientDetails()
+	servicesutils.SetContentType("application/json", &httpClientDetails.Headers)
+	resp, respBody, err := servicesManager.Client().SendPost(requestUrl, content, &httpClientDetails)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("Artifactory response: %s %s", resp.Status, string(respBody))
+	}
+	return nil
+}
+
+// Returns the numbers of the builds the retention policy discards, the same way Artifactory does.
+// 'builds' are sorted from the latest to the oldest started. The builds in 'keep' are never discarded.
+func selectBuildsToDiscard(builds []publishedBuild, retention *Retention, keep []string, now time.Time) []string {
+	minStarted := now.Add(-24 * time.Hour * time.Duration(retention.MaxDays))
+	var toDiscard []string
+	for i, build := range builds {
+		if containsString(keep, build.Number) {
+			continue
+		}
+		if (retention.MaxBuilds > 0 && i >= retention.MaxBuilds) || (retention.MaxDays > 0 && build.Started.Before(minStarted)) {
+			toDiscard = append(toDiscard, build.Number)
+		}
+	}
+	return toDiscard
+}
+
+// Mark a build in which Xray found violations, so that retention policies with 'KeepViolations' keep it.
+func MarkBuildViolations(servicesManager artifactory.ArtifactoryServicesManager, buildName, buildNumber string, jfrog *JfrogDetails) error {
+	return setBuildInfoProps(servicesManager, buildName, buildNumber, violationsProperty+"=true", jfrog)
+}
+
+// Returns the numbers of the builds of buildName which were marked with violations.
+func getViolationsBuildNumbers(servicesManager artifactory.ArtifactoryServicesManager, buildName string, jfrog *JfrogDetails) ([]string, error) {
+	params := services.NewSearchParams()
+	params.Pattern = getBuildInfoRepo(jfrog) + "/" + buildName + "/*.json"
+	params.Props = violationsProperty + "=true"
+	reader, err := servicesManager.SearchFiles(params)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if reader.IsEmpty() {
+		return nil, nil
+	}
+	var numbers []string
+	for {
+		item := new(servicesutils.ResultItem)
+		if err = reader.NextRecord(item); err == io.EOF {
+			return numbers, reader.GetError()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if match := buildInfoFileNamePattern.FindStringSubmatch(item.Name); match != nil && !containsString(numbers, match[1]) {
+			numbers = append(numbers, match[1])
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jfrog/jfrog-client-go/artifactory/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRetention(t *testing.T) {
+	jfrog := &JfrogDetails{Retention: []Retention{
+		{Branches: []string{"release/*"}, MaxBuilds: 100},
+		{Branches: []string{".*"}, MaxBuilds: 10},
+	}}
+	assert.Equal(t, 100, GetRetention("release/1.0", jfrog).MaxBuilds)
+	assert.Equal(t, 10, GetRetention("feature/foo", jfrog).MaxBuilds)
+	assert.Nil(t, GetRetention("master", &JfrogDetails{}))
+}
+
+func TestSelectBuildsToDiscard(t *testing.T) {
+	now := time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC)
+	builds := []publishedBuild{
+		{Number: "5", Started: now.Add(-1 * time.Hour)},
+		{Number: "4", Started: now.Add(-25 * time.Hour)},
+		{Number: "3", Started: now.Add(-49 * time.Hour)},
+		{Number: "2", Started: now.Add(-73 * time.Hour)},
+		{Number: "1", Started: now.Add(-97 * time.Hour)},
+	}
+	assert.Equal(t, []string{"2", "1"}, selectBuildsToDiscard(builds, &Retention{MaxBuilds: 3}, nil, now))
+	assert.Equal(t, []string{"3", "2", "1"}, selectBuildsToDiscard(builds, &Retention{MaxDays: 2}, nil, now))
+	assert.Equal(t, []string{"4", "3", "1"}, selectBuildsToDiscard(builds, &Retention{MaxBuilds: 1, MaxDays: 3}, []string{"2"}, now))
+	assert.Empty(t, selectBuildsToDiscard(builds, &Retention{MaxBuilds: 10}, nil, now))
+}
+
+func TestApplyRetention(t *testing.T) {
+	var discardRequests []services.DiscardBuildsBody
+	var discardProjects []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/search/aql":
+			_, err := w.Write([]byte(`{"results":[{"repo":"artifactory-build-info","path":"my-build","name":"2-1-rc-1610000000000.json","type":"file"}]}`))
+			assert.NoError(t, err)
+		case "/api/build/my-build":
+			_, err := w.Write([]byte(`{"buildsNumbers":[{"uri":"/3","started":"2021-01-10T10:00:00.000+0000"},{"uri":"/2-1-rc","started":"2021-01-09T10:00:00.000+0000"},{"uri":"/1","started":"2021-01-08T10:00:00.000+0000"}]}`))
+			assert.NoError(t, err)
+		case "/api/build/retention/my-build":
+			body := services.DiscardBuildsBody{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			discardRequests = append(discardRequests, body)
+			discardProjects = append(discardProjects, r.URL.Query().Get("project"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	servicesManager := createTestServicesManager(t, server)
+
+	retention := &Retention{MaxBuilds: 1, KeepViolations: true, DeleteArtifacts: true}
+	assert.NoError(t, ApplyRetention(servicesManager, "my-build", retention, &JfrogDetails{}))
+	assert.Equal(t, []services.DiscardBuildsBody{{MaxBuilds: "1", ExcludeBuilds: []string{"2-1-rc"}, DeleteArtifacts: true}}, discardRequests)
+
+	// Dry run and disabled policies don't discard builds.
+	retention.DryRun = true
+	assert.NoError(t, ApplyRetention(servicesManager, "my-build", retention, &JfrogDetails{}))
+	assert.NoError(t, ApplyRetention(servicesManager, "my-build", &Retention{}, &JfrogDetails{}))
+	assert.NoError(t, ApplyRetention(servicesManager, "my-build", nil, &JfrogDetails{}))
+	assert.Len(t, discardRequests, 1)
+
+	// The builds of a JFrog Project are discarded from the project.
+	assert.NoError(t, ApplyRetention(servicesManager, "my-build", &Retention{MaxBuilds: 1}, &JfrogDetails{ProjectKey: "proj"}))
+	assert.Equal(t, []string{"", "proj"}, discardProjects)
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = 30 * time.Second
+	defaultJitter      = 0.2
+	// The JFrog CLI exit code of a build scan which found violations. Retrying won't change the result.
+	vulnerableBuildExitCode = 3
+)
+
+// Retry policy of the network operations: clone, fetch, build-info download, and the publish and scan commands.
+type RetryPolicy struct {
+	// The maximum number of attempts, including the first one. Defaults to 3. Set to 1 to disable retries.
+	MaxAttempts int `yaml:"maxAttempts"`
+	// The delay before the first retry, such as '2s'. Doubled on each retry. Defaults to 1s.
+	Backoff time.Duration `yaml:"backoff"`
+	// The maximum delay between attempts. Defaults to 30s.
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// The fraction of the delay which is randomly added or removed from it, between 0 and 1. Defaults to 0.2.
+	Jitter float64 `yaml:"jitter"`
+}
+
+// The retry policy used by Retry.
+var retryPolicy = &RetryPolicy{}
+
+// Overridden in tests.
+var (
+	sleep  = time.Sleep
+	random = rand.Float64
+)
+
+// Set the retry policy of the network operations. If the policy is nil, the defaults are used.
+func ConfigureRetry(policy *RetryPolicy) {
+	if policy == nil {
+		policy = &RetryPolicy{}
+	}
+	retryPolicy = policy
+}
+
+// Run the operation, and retry it while it fails with a retryable error, according to the retry policy.
+// Returns the error of the last attempt.
+func Retry(description string, operation func() error) error {
+	maxAttempts := retryPolicy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = defaultMaxAttempts
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = operation(); err == nil || attempt >= maxAttempts || !isRetryable(err) {
+			return err
+		}
+		delay := retryPolicy.getDelay(attempt)
+		log.Warn(fmt.Sprintf("%s failed (attempt %d/%d), retrying in %s. Error: %s", description, attempt, maxAttempts, delay, err.Error()))
+		sleep(delay)
+	}
+}
+
+// Returns the delay after the failed attempt: an exponential backoff, capped by 'MaxBackoff', with a random jitter.
+func (p *RetryPolicy) getDelay(attempt int) time.Duration {
+	backoff, maxBackoff, jitter := p.Backoff, p.MaxBackoff, p.Jitter
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	if jitter <= 0 || jitter > 1 {
+		jitter = defaultJitter
+	}
+	delay := backoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return time.Duration(float64(delay) * (1 + jitter*(2*random()-1)))
+}
+
+// Transient errors are retryable: connection errors, timeouts, and the HTTP status codes 408, 429 and 5xx.
+// Authentication, authorization, not found, TLS certificate and other client errors are not.
+// A failure which is expected to pass on retry, such as a search of a file which Artifactory hasn't indexed yet.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+func isRetryable(err error) bool {
+	var transientErr *transientError
+	if errors.As(err, &transientErr) {
+		return true
+	}
+	var cmdErr *cmdError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.exitCode != vulnerableBuildExitCode && isRetryableMessage(cmdErr.stderr)
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCertificate x509.CertificateInvalidError
+	var invalidHostname x509.HostnameError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalidCertificate) || errors.As(err, &invalidHostname) {
+		return false
+	}
+	var httpErr *githttp.Err
+	if errors.As(err, &httpErr) {
+		return isRetryableStatus(httpErr.Response.StatusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	return isRetryableMessage(err.Error())
+}
+
+// Matches the HTTP status codes in the error messages of jfrog-client-go, JFrog CLI and git.
+var statusCodePattern = regexp.MustCompile(`(?:^|:\s*)(\d{3})(?:\s|$)`)
+
+// Error messages of transient failures, reported by commands and libraries as text.
+var transientErrorMessages = []string{
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"timeout",
+	"timed out",
+	"unexpected eof",
+	"early eof",
+	"temporary failure in name resolution",
+	"tls handshake",
+}
+
+func isRetryableMessage(message string) bool {
+	if match := statusCodePattern.FindStringSubmatch(message); match != nil {
+		statusCode, _ := strconv.Atoi(match[1])
+		return isRetryableStatus(statusCode)
+	}
+	message = strings.ToLower(message)
+	for _, transient := range transientErrorMessages {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case 408, 429, 500, 502, 503, 504:
+		return true
+	}
+	return false
+}
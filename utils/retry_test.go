@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/jfrog/jfrog-client-go/artifactory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLatestBuildInfoRetry(t *testing.T) {
+	delays := setupRetryTest(t, &RetryPolicy{MaxAttempts: 4, Backoff: time.Second})
+
+	// The build API fails twice with '502 Bad Gateway', and then succeeds.
+	server, requests := createFlakyServer(t, 2, http.StatusBadGateway, `{"buildsNumbers":[]}`)
+	bi, err := GetLatestBuildInfo(createTestServicesManager(t, server), "build", &JfrogDetails{})
+	assert.NoError(t, err)
+	assert.Nil(t, bi)
+	assert.Equal(t, 3, *requests)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *delays)
+
+	// '401 Unauthorized' is not retried.
+	*delays = nil
+	server, requests = createFlakyServer(t, 2, http.StatusUnauthorized, `{"buildsNumbers":[]}`)
+	_, err = GetLatestBuildInfo(createTestServicesManager(t, server), "build", &JfrogDetails{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, *requests)
+	assert.Empty(t, *delays)
+
+	// The attempts are exhausted.
+	server, requests = createFlakyServer(t, 10, http.StatusServiceUnavailable, `{"buildsNumbers":[]}`)
+	_, err = GetLatestBuildInfo(createTestServicesManager(t, server), "build", &JfrogDetails{})
+	assert.Error(t, err)
+	assert.Equal(t, 4, *requests)
+}
+
+func TestSetBuildInfoPropsRetry(t *testing.T) {
+	delays := setupRetryTest(t, &RetryPolicy{MaxAttempts: 3, Backoff: time.Second})
+
+	// The first search fails with '502 Bad Gateway', and the second doesn't find the build-info file, which isn't indexed yet.
+	searches := 0
+	server, requests := createFlakyHandlerServer(t, 1, http.StatusBadGateway, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/search/aql":
+			searches++
+			var results string
+			if searches > 1 {
+				results = `{"repo":"artifactory-build-info","path":"my-build","name":"1-1.json","type":"file"}`
+			}
+			_, err := w.Write([]byte(`{"results":[` + results + `]}`))
+			assert.NoError(t, err)
+		case "/api/storage/artifactory-build-info/my-build/1-1.json":
+			assert.Equal(t, "key=value", r.URL.Query().Get("properties"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	assert.NoError(t, setBuildInfoProps(createTestServicesManager(t, server), "my-build", "1", "key=value", &JfrogDetails{}))
+	assert.Equal(t, 4, *requests)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *delays)
+
+	// The build-info file is never found.
+	*delays = nil
+	server, requests = createFlakyServer(t, 0, http.StatusOK, `{"results":[]}`)
+	err := setBuildInfoProps(createTestServicesManager(t, server), "my-build", "1", "key=value", &JfrogDetails{})
+	assert.EqualError(t, err, "the build-info file of build 'my-build/1' is not found in 'artifactory-build-info'")
+	assert.Equal(t, 3, *requests)
+}
+
+func TestRetryLfsBatch(t *testing.T) {
+	setupRetryTest(t, &RetryPolicy{})
+	content := "vendored dependencies archive"
+	checksum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(checksum[:])
+
+	// Both the batch request and the download fail once with '504 Gateway Timeout'.
+	storage, downloads := createFlakyServer(t, 1, http.StatusGatewayTimeout, content)
+	server, requests := createFlakyServer(t, 1, http.StatusGatewayTimeout, fmt.Sprintf(`{"objects":[{"oid":"%s","size":%d,"actions":{"download":{"href":"%s/%s"}}}]}`, oid, len(content), storage.URL, oid))
+	r, path := createRepoWithFiles(t, map[string]string{
+		"archive.tgz": fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, oid, len(content)),
+	})
+	assert.NoError(t, SmudgeLfsFiles(path, r, &Vcs{LfsUrl: server.URL}))
+	assert.Equal(t, 2, *requests)
+	assert.Equal(t, 2, *downloads)
+	assertFileContent(t, filepath.Join(path, "archive.tgz"), content)
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{errors.New("502 Bad Gateway <html></html>"), true},
+		{errors.New("Git LFS batch request failed: 429 Too Many Requests"), true},
+		{errors.New("git fetch failed: exit status 128: fatal: unable to access 'https://git/': The requested URL returned error: 503"), true},
+		{errors.New("git fetch failed: exit status 128: fatal: the remote end hung up unexpectedly: early EOF"), true},
+		{errors.New("read tcp 10.0.0.1:443: connection reset by peer"), true},
+		{io.ErrUnexpectedEOF, true},
+		{fmt.Errorf("failed to download: %w", io.ErrUnexpectedEOF), true},
+		{errors.New("404 Not Found"), false},
+		{errors.New("Git LFS batch request failed: 401 Unauthorized"), false},
+		{transport.ErrAuthenticationRequired, false},
+		{transport.ErrRepositoryNotFound, false},
+		{&cmdError{err: errors.New("exit status 1"), exitCode: 1, stderr: "[Error] server response: 500 Internal Server Error"}, true},
+		{&cmdError{err: errors.New("exit status 1"), exitCode: 1, stderr: "[Error] server response: 403 Forbidden"}, false},
+		{&cmdError{err: errors.New("exit status 3"), exitCode: vulnerableBuildExitCode, stderr: "[Error] Violations were found: timeout"}, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.retryable, isRetryable(test.err), test.err.Error())
+	}
+}
+
+func TestGetDelay(t *testing.T) {
+	random = func() float64 { return 1 }
+	defer func() { random = rand.Float64 }()
+	policy := &RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second, Jitter: 0.5}
+	assert.Equal(t, 1500*time.Millisecond, policy.getDelay(1))
+	assert.Equal(t, 3*time.Second, policy.getDelay(2))
+	assert.Equal(t, 6*time.Second, policy.getDelay(3))
+	assert.Equal(t, 7500*time.Millisecond, policy.getDelay(10))
+
+	random = func() float64 { return 0 }
+	assert.Equal(t, 800*time.Millisecond, (&RetryPolicy{}).getDelay(1))
+	assert.Equal(t, 24*time.Second, (&RetryPolicy{}).getDelay(100))
+}
+
+// Set the retry policy without jitter, and record the delays instead of sleeping.
+func setupRetryTest(t *testing.T, policy *RetryPolicy) *[]time.Duration {
+	var delays []time.Duration
+	ConfigureRetry(policy)
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	random = func() float64 { return 0.5 }
+	t.Cleanup(func() {
+		ConfigureRetry(nil)
+		sleep = time.Sleep
+		random = rand.Float64
+	})
+	return &delays
+}
+
+// Returns a server which responds with 'failureStatus' to the first 'failures' requests, and then with 'body'.
+func createFlakyServer(t *testing.T, failures, failureStatus int, body string) (*httptest.Server, *int) {
+	return createFlakyHandlerServer(t, failures, failureStatus, func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(body))
+		assert.NoError(t, err)
+	})
+}
+
+// Returns a server which responds with 'failureStatus' to the first 'failures' requests, and then by 'handler'.
+func createFlakyHandlerServer(t *testing.T, failures, failureStatus int, handler http.HandlerFunc) (*httptest.Server, *int) {
+	requests := new(int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if *requests <= failures {
+			w.WriteHeader(failureStatus)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func createTestServicesManager(t *testing.T, server *httptest.Server) artifactory.ArtifactoryServicesManager {
+	servicesManager, err := createServiceManager(&BuildConfig{Jfrog: &JfrogDetails{ArtUrl: server.URL + "/", User: "user", Password: "password"}}, "")
+	assert.NoError(t, err)
+	return servicesManager
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A semantic version, such as 'v1.2.3' or '1.2.3-rc.1'. Build metadata is ignored.
+type semVersion struct {
+	major, minor, patch int
+	prerelease          string
+}
+
+// Parse a semantic version. The 'v' prefix and the minor and patch parts are optional.
+func parseSemVersion(version string) (*semVersion, error) {
+	v := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if idx := strings.Index(v, "+"); idx >= 0 {
+		v = v[:idx]
+	}
+	sv := new(semVersion)
+	if idx := strings.Index(v, "-"); idx >= 0 {
+		sv.prerelease = v[idx+1:]
+		v = v[:idx]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("'%s' is not a semantic version", version)
+	}
+	numbers := []*int{&sv.major, &sv.minor, &sv.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("'%s' is not a semantic version", version)
+		}
+		*numbers[i] = n
+	}
+	return sv, nil
+}
+
+func (v *semVersion) String() string {
+	version := fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	if v.prerelease != "" {
+		version += "-" + v.prerelease
+	}
+	return version
+}
+
+// Returns -1, 0 or 1 if 'v' is lower than, equal to or greater than 'other'.
+// A pre-release version is lower than its release. Pre-releases are compared lexically.
+func (v *semVersion) compare(other *semVersion) int {
+	for _, diff := range []int{v.major - other.major, v.minor - other.minor, v.patch - other.patch} {
+		if diff != 0 {
+			return sign(diff)
+		}
+	}
+	switch {
+	case v.prerelease == other.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case other.prerelease == "":
+		return -1
+	}
+	return sign(strings.Compare(v.prerelease, other.prerelease))
+}
+
+func sign(n int) int {
+	if n < 0 {
+		return -1
+	}
+	if n > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Returns true if 'version' is in the range 'versionsRange'.
+// A range is a list of comparator sets separated by '||'. A comparator set is a space separated list of comparators, which must all be satisfied.
+// For example: '>=1.2.0 <2.0.0 || 3.0.0'.
+func matchSemVersionRange(versionsRange string, version *semVersion) (bool, error) {
+	for _, comparatorSet := range strings.Split(versionsRange, "||") {
+		matched := true
+		for _, comparator := range strings.Fields(comparatorSet) {
+			satisfied, err := matchSemVersionComparator(comparator, version)
+			if err != nil {
+				return false, err
+			}
+			matched = matched && satisfied
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns an error if a comparator of 'versionsRange' is invalid.
+func validateSemVersionRange(versionsRange string) error {
+	for _, comparatorSet := range strings.Split(versionsRange, "||") {
+		for _, comparator := range strings.Fields(comparatorSet) {
+			if _, _, err := parseSemVersionComparator(comparator); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func matchSemVersionComparator(comparator string, version *semVersion) (bool, error) {
+	op, other, err := parseSemVersionComparator(comparator)
+	if err != nil {
+		return false, err
+	}
+	cmp := version.compare(other)
+	switch op {
+	case ">=":
+		return cmp >= 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case ">":
+		return cmp > 0, nil
+	case "<":
+		return cmp < 0, nil
+	}
+	return cmp == 0, nil
+}
+
+// Parse a comparator, such as '>=1.2.0', to its operator and version. A version without an operator is compared by '='.
+func parseSemVersionComparator(comparator string) (string, *semVersion, error) {
+	for _, op := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if strings.HasPrefix(comparator, op) {
+			version, err := parseSemVersion(strings.TrimPrefix(comparator, op))
+			return op, version, err
+		}
+	}
+	version, err := parseSemVersion(comparator)
+	return "=", version, err
+}
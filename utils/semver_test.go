@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSemVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected *semVersion
+	}{
+		{"v1.2.3", &semVersion{major: 1, minor: 2, patch: 3}},
+		{"1.2", &semVersion{major: 1, minor: 2}},
+		{"2.0.0-rc.1+build.5", &semVersion{major: 2, prerelease: "rc.1"}},
+	}
+	for _, test := range tests {
+		version, err := parseSemVersion(test.version)
+		assert.NoError(t, err, test.version)
+		assert.Equal(t, test.expected, version, test.version)
+	}
+	for _, invalid := range []string{"", "release-1", "1.2.3.4", "v1.x"} {
+		_, err := parseSemVersion(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestMatchSemVersionRange(t *testing.T) {
+	tests := []struct {
+		versionsRange string
+		version       string
+		expected      bool
+	}{
+		{">=1.2.0 <2.0.0", "1.2.0", true},
+		{">=1.2.0 <2.0.0", "2.0.0", false},
+		{">=1.2.0 <2.0.0", "2.0.0-rc.1", true},
+		{">=1.2.0 <2.0.0 || 3.0.0", "3.0.0", true},
+		{"!=1.0.0", "1.0.0", false},
+		{">1.0.0", "v1.0.1", true},
+		{"<=1.0.0", "1.0.0-beta", true},
+	}
+	for _, test := range tests {
+		version, err := parseSemVersion(test.version)
+		assert.NoError(t, err)
+		matched, err := matchSemVersionRange(test.versionsRange, version)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, matched, test.versionsRange+" "+test.version)
+	}
+	version, err := parseSemVersion("1.0.0")
+	assert.NoError(t, err)
+	_, err = matchSemVersionRange(">=one", version)
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+type SubmodulePolicy string
+
+const (
+	// Don't clone submodules.
+	NoSubmodules SubmodulePolicy = "none"
+	// Clone only the submodules of the project.
+	TopLevelSubmodules SubmodulePolicy = "top-level"
+	// Clone the submodules of the project, and their nested submodules.
+	RecursiveSubmodules SubmodulePolicy = "recursive"
+)
+
+// Credentials for submodules hosted on a different server than the project.
+type SubmoduleCredentials struct {
+	// The credentials are used for submodules with a URL starting with this prefix.
+	UrlPrefix string `yaml:"urlPrefix"`
+	User      string `yaml:"user"`
+	Password  string `yaml:"password"`
+	Token     string `yaml:"token"`
+}
+
+// Returns the maximum depth of nested submodules to update.
+func getSubmodulesDepth(vcs *Vcs) (git.SubmoduleRescursivity, error) {
+	switch vcs.Submodules {
+	case NoSubmodules:
+		return git.NoRecurseSubmodules, nil
+	case TopLevelSubmodules:
+		return 1, nil
+	case "", RecursiveSubmodules:
+		return git.DefaultSubmoduleRecursionDepth, nil
+	}
+	return 0, fmt.Errorf("unknown submodules policy '%s', expecting one of: %s, %s, %s", vcs.Submodules, NoSubmodules, TopLevelSubmodules, RecursiveSubmodules)
+}
+
+// Initialize and update the submodules of the checked out commit, according to the submodules policy.
+// Run after each checkout, to make the submodules match the commit.
+func UpdateSubmodules(r *git.Repository, vcs *Vcs) error {
+	depth, err := getSubmodulesDepth(vcs)
+	if err != nil || depth == git.NoRecurseSubmodules {
+		return err
+	}
+	return updateSubmodules(r, vcs.Url, vcs, depth)
+}
+
+func updateSubmodules(r *git.Repository, parentUrl string, vcs *Vcs, depth git.SubmoduleRescursivity) error {
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	submodules, err := w.Submodules()
+	if err != nil {
+		return err
+	}
+	for _, submodule := range submodules {
+		config := submodule.Config()
+		config.URL = resolveSubmoduleUrl(parentUrl, config.URL)
+		log.Info("Updating submodule '" + config.Path + "'")
+		options := &git.SubmoduleUpdateOptions{Init: true, Auth: createSubmoduleCredentials(config.URL, vcs)}
+		if err = Retry("Updating submodule '"+config.Path+"'", func() error { return submodule.Update(options) }); err != nil {
+			return fmt.Errorf("failed to update submodule '%s': %s", config.Path, err.Error())
+		}
+		if depth <= 1 {
+			continue
+		}
+		// Nested submodules are updated one by one, since each of them may need different credentials.
+		subRepo, err := submodule.Repository()
+		if err != nil {
+			return err
+		}
+		if err = updateSubmodules(subRepo, config.URL, vcs, depth-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve a submodule URL which is relative to its parent repository URL, such as '../common.git'.
+func resolveSubmoduleUrl(parentUrl, submoduleUrl string) string {
+	if !strings.HasPrefix(submoduleUrl, "./") && !strings.HasPrefix(submoduleUrl, "../") {
+		return submoduleUrl
+	}
+	if u, err := url.Parse(parentUrl); err == nil && u.Scheme != "" {
+		u.Path = path.Join(u.Path, submoduleUrl)
+		return u.String()
+	}
+	return path.Join(parentUrl, submoduleUrl)
+}
+
+// Returns the credentials of the longest matching 'SubmoduleCredentials' prefix.
+// If none matches, the project credentials are used only for a submodule on the project server, so they aren't sent to other servers.
+func createSubmoduleCredentials(submoduleUrl string, vcs *Vcs) transport.AuthMethod {
+	var match *SubmoduleCredentials
+	for i, credentials := range vcs.SubmoduleCredentials {
+		if strings.HasPrefix(submoduleUrl, credentials.UrlPrefix) && (match == nil || len(credentials.UrlPrefix) > len(match.UrlPrefix)) {
+			match = &vcs.SubmoduleCredentials[i]
+		}
+	}
+	if match == nil {
+		if isSameOrigin(submoduleUrl, vcs.Url) {
+			return createCredentials(vcs)
+		}
+		return nil
+	}
+	return &http.BasicAuth{Username: match.User, Password: tokenOrPassword(match.Token, match.Password)}
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateSubmodules(t *testing.T) {
+	sub, subPath := createRepoWithFiles(t, map[string]string{"version.txt": "1"})
+	subCommit1, err := sub.Head()
+	assert.NoError(t, err)
+	subCommit2 := commitFile(t, sub, subPath, "version.txt", "2")
+
+	// The project points to the first submodule commit, and then to the second.
+	project, projectPath := createRepoWithFiles(t, map[string]string{".gitmodules": "[submodule \"sub\"]\n\tpath = sub\n\turl = " + subPath + "\n"})
+	projectCommit1 := commitSubmodule(t, project, "sub", subCommit1.Hash())
+	commitSubmodule(t, project, "sub", subCommit2)
+
+	vcs := &Vcs{Url: projectPath, Submodules: TopLevelSubmodules}
+	b := cloneToTempDir(t, vcs)
+	r := repository(t, b)
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+	assertFileContent(t, filepath.Join(w.Filesystem.Root(), "sub", "version.txt"), "2")
+
+	assert.NoError(t, CheckoutHash(projectCommit1.String(), b))
+	assert.NoError(t, UpdateSubmodules(r, vcs))
+	assertFileContent(t, filepath.Join(w.Filesystem.Root(), "sub", "version.txt"), "1")
+
+	// Submodules are not cloned.
+	vcs.Submodules = NoSubmodules
+	r = repository(t, cloneToTempDir(t, vcs))
+	w, err = r.Worktree()
+	assert.NoError(t, err)
+	_, err = ioutil.ReadFile(filepath.Join(w.Filesystem.Root(), "sub", "version.txt"))
+	assert.Error(t, err)
+
+	vcs.Submodules = "all"
+	assert.Error(t, UpdateSubmodules(r, vcs))
+}
+
+func TestResolveSubmoduleUrl(t *testing.T) {
+	assert.Equal(t, "https://github.com/jfrog/common.git", resolveSubmoduleUrl("https://github.com/jfrog/project.git", "../common.git"))
+	assert.Equal(t, "https://github.com/jfrog/project.git/common", resolveSubmoduleUrl("https://github.com/jfrog/project.git", "./common"))
+	assert.Equal(t, "/repos/common", resolveSubmoduleUrl("/repos/project", "../common"))
+	assert.Equal(t, "https://gitlab.com/jfrog/common.git", resolveSubmoduleUrl("https://github.com/jfrog/project.git", "https://gitlab.com/jfrog/common.git"))
+}
+
+func TestCreateSubmoduleCredentials(t *testing.T) {
+	vcs := &Vcs{
+		Url:   "https://github.com/jfrog/project.git",
+		User:  "github-user",
+		Token: "github-token",
+		SubmoduleCredentials: []SubmoduleCredentials{
+			{UrlPrefix: "https://gitlab.com/", User: "gitlab-user", Password: "gitlab-password"},
+			{UrlPrefix: "https://gitlab.com/jfrog/", User: "jfrog-user", Token: "jfrog-token"},
+		},
+	}
+	assert.Equal(t, &http.BasicAuth{Username: "github-user", Password: "github-token"}, createSubmoduleCredentials("https://github.com/jfrog/common.git", vcs))
+	assert.Equal(t, &http.BasicAuth{Username: "gitlab-user", Password: "gitlab-password"}, createSubmoduleCredentials("https://gitlab.com/other/common.git", vcs))
+	assert.Equal(t, &http.BasicAuth{Username: "jfrog-user", Password: "jfrog-token"}, createSubmoduleCredentials("https://gitlab.com/jfrog/common.git", vcs))
+	// The project credentials aren't sent to other servers.
+	assert.Nil(t, createSubmoduleCredentials("https://bitbucket.org/jfrog/common.git", vcs))
+}
+
+func commitFile(t *testing.T, r *git.Repository, path, name, content string) plumbing.Hash {
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(path, name), []byte(content), 0644))
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+	_, err = w.Add(name)
+	assert.NoError(t, err)
+	hash, err := w.Commit("Update "+name, &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@jfrog.com"}})
+	assert.NoError(t, err)
+	return hash
+}
+
+// Commit a submodule entry pointing to 'hash'. go-git can't add submodules, therefore the index is updated directly.
+func commitSubmodule(t *testing.T, r *git.Repository, path string, hash plumbing.Hash) plumbing.Hash {
+	idx, err := r.Storer.Index()
+	assert.NoError(t, err)
+	entry, err := idx.Entry(path)
+	if err == index.ErrEntryNotFound {
+		entry = idx.Add(path)
+		err = nil
+	}
+	assert.NoError(t, err)
+	entry.Mode = filemode.Submodule
+	entry.Hash = hash
+	assert.NoError(t, r.Storer.SetIndex(idx))
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+	commit, err := w.Commit("Update submodule", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@jfrog.com"}})
+	assert.NoError(t, err)
+	return commit
+}
+
+func assertFileContent(t *testing.T, path, expected string) {
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(content))
+}
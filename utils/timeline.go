@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/jfrog/jfrog-client-go/artifactory"
+	"github.com/jfrog/jfrog-client-go/artifactory/services"
+	servicesutils "github.com/jfrog/jfrog-client-go/artifactory/services/utils"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+// The file of the vulnerability timeline of a branch, stored in '<ScanResultsRepo>/<buildName>/'.
+const vulnerabilityTimelineFile = "vulnerability-timeline.json"
+
+// The file names of dependency manifests and lock files. A new finding is attributed to the changes of these files.
+var manifestPatterns = []string{
+	"pom.xml",
+	"build.gradle", "build.gradle.kts", "settings.gradle", "settings.gradle.kts", "gradle.lockfile",
+	"package.json", "package-lock.json", "npm-shrinkwrap.json", "yarn.lock",
+	"go.mod", "go.sum",
+	"requirements*.txt", "setup.py", "Pipfile", "Pipfile.lock", "pyproject.toml", "poetry.lock",
+	"*.csproj", "packages.config",
+}
+
+// The findings of Xray on the scanned commits of a branch, from the commit which introduced each finding to the commit which fixed it.
+type VulnerabilityTimeline struct {
+	Branch string `json:"branch"`
+	// The last scanned commit. The open findings are the findings of this commit.
+	LastCommit string             `json:"lastCommit"`
+	Findings   []*TimelineFinding `json:"findings"`
+	buildName  string
+	repo       string
+}
+
+type TimelineFinding struct {
+	ScanIssue
+	// The finding was found by the first scan of the branch, so the commit which introduced it is unknown.
+	Baseline   bool            `json:"baseline,omitempty"`
+	Introduced *TimelineCommit `json:"introduced"`
+	// Nil while the finding is open.
+	Fixed *TimelineCommit `json:"fixed,omitempty"`
+}
+
+// A scanned commit, which introduced or fixed findings.
+type TimelineCommit struct {
+	Commit      string    `json:"commit"`
+	Author      string    `json:"author"`
+	Date        time.Time `json:"date"`
+	BuildNumber string    `json:"buildNumber"`
+	// The dependency manifests which changed since the previously scanned commit.
+	Manifests []string `json:"manifests,omitempty"`
+}
+
+// Returns the vulnerability timeline of the branch which is published under buildName.
+// Returns nil if 'jfrog.ScanResultsRepo' isn't set, and an empty timeline if the branch wasn't scanned before.
+func LoadVulnerabilityTimeline(servicesManager artifactory.ArtifactoryServicesManager, buildName, branch string, jfrog *JfrogDetails) (*VulnerabilityTimeline, error) {
+	if jfrog.ScanResultsRepo == "" {
+		return nil, nil
+	}
+	timeline := &VulnerabilityTimeline{Branch: branch, buildName: buildName, repo: jfrog.ScanResultsRepo}
+	err := Retry("Reading the vulnerability timeline of '"+buildName+"'", func() error {
+		timelinePath := &url.URL{Path: timeline.repo + "/" + buildName + "/" + vulnerabilityTimelineFile}
+		_, err := getArtifactoryJson(servicesManager, timelinePath.EscapedPath(), nil, timeline)
+		return err
+	})
+	return timeline, err
+}
+
+// Record the scan result of a commit in the timeline, and store both the timeline and the scan result.
+// The scan result is stored in '<ScanResultsRepo>/<buildName>/<buildNumber>.json'. The findings which the commit introduced are logged.
+// Returns the findings of the commit compared to the previous scanned commit, or nil if they can't be compared.
+func (t *VulnerabilityTimeline) Record(servicesManager artifactory.ArtifactoryServicesManager, b GitBackend, hash, buildNumber string, result *ScanResult) (ScanDiff, error) {
+	if t == nil {
+		return nil, nil
+	}
+	if result == nil {
+		// Without a result, the open findings would be recorded as fixed.
+		log.Warn("The scan result of commit '" + hash + "' is missing, it isn't recorded in the vulnerability timeline")
+		return nil, nil
+	}
+	commit, err := t.newTimelineCommit(b, hash, buildNumber)
+	if err != nil {
+		return nil, err
+	}
+	baseline := t.LastCommit == ""
+	diff := t.update(commit, result.Issues())
+	for _, finding := range diff {
+		switch {
+		case finding.Status == NewFinding && !baseline:
+			log.Info(formatIntroducedFinding(commit, finding.ScanIssue))
+		case finding.Status == FixedFinding:
+			log.Info("Commit '" + ToShortCommitHash(hash) + "' fixed " + formatFinding(finding.ScanIssue))
+		}
+	}
+	props := formatProps(servicesutils.SplitCommas,
+		servicesutils.Property{Key: "build.name", Value: t.buildName},
+		servicesutils.Property{Key: "build.number", Value: buildNumber},
+		servicesutils.Property{Key: "vcs.revision", Value: hash})
+	if err = uploadJson(servicesManager, t.repo+"/"+t.buildName+"/"+buildNumber+".json", props, result); err != nil {
+		return diff, err
+	}
+	props = formatProps(servicesutils.SplitCommas,
+		servicesutils.Property{Key: "build.name", Value: t.buildName},
+		servicesutils.Property{Key: "vcs.branch", Value: t.Branch})
+	return diff, uploadJson(servicesManager, t.repo+"/"+t.buildName+"/"+vulnerabilityTimelineFile, props, t)
+}
+
+// Returns the details of a scanned commit. The changed manifests are skipped for the first scanned commit,
+// and logged and skipped if the previously scanned commit can't be compared.
+func (t *VulnerabilityTimeline) newTimelineCommit(b GitBackend, hash, buildNumber string) (*TimelineCommit, error) {
+	gitRepo, err := b.Repository()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := gitRepo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, err
+	}
+	timelineCommit := &TimelineCommit{
+		Commit:      hash,
+		Author:      commit.Author.Name + " <" + commit.Author.Email + ">",
+		Date:        commit.Author.When.UTC(),
+		BuildNumber: buildNumber,
+	}
+	if t.LastCommit == "" {
+		return timelineCommit, nil
+	}
+	files, err := b.Diff(t.LastCommit, hash)
+	if err != nil {
+		log.Warn("Failed to find the manifests changed by commit '" + hash + "': " + err.Error())
+		return timelineCommit, nil
+	}
+	for _, file := range files {
+		if isManifest(file) {
+			timelineCommit.Manifests = append(timelineCommit.Manifests, file)
+		}
+	}
+	return timelineCommit, nil
+}
+
+// Update the timeline with the issues found in a commit, the next scanned commit of the branch.
+// Returns the findings of the commit compared to the previous scanned commit. All the findings of the first scanned commit are new.
+func (t *VulnerabilityTimeline) update(commit *TimelineCommit, issues []ScanIssue) ScanDiff {
+	open := map[string]*TimelineFinding{}
+	for _, finding := range t.Findings {
+		if finding.Fixed == nil {
+			open[findingKey(finding.ScanIssue)] = finding
+		}
+	}
+	found := map[string]bool{}
+	var diff ScanDiff
+	for _, issue := range issues {
+		key := findingKey(issue)
+		if found[key] {
+			continue
+		}
+		found[key] = true
+		if finding, ok := open[key]; ok {
+			// Keep the latest details, such as an updated severity.
+			finding.ScanIssue = issue
+			diff = append(diff, ScanFinding{ScanIssue: issue, Status: UnchangedFinding})
+			continue
+		}
+		t.Findings = append(t.Findings, &TimelineFinding{ScanIssue: issue, Baseline: t.LastCommit == "", Introduced: commit})
+		diff = append(diff, ScanFinding{ScanIssue: issue, Status: NewFinding})
+	}
+	for _, finding := range t.Findings {
+		if finding.Fixed == nil && !found[findingKey(finding.ScanIssue)] {
+			finding.Fixed = commit
+			diff = append(diff, ScanFinding{ScanIssue: finding.ScanIssue, Status: FixedFinding})
+		}
+	}
+	t.LastCommit = commit.Commit
+	return diff
+}
+
+// Identifies a finding across scans. Security issues are identified by their CVE, when they have one.
+func findingKey(issue ScanIssue) string {
+	if issue.Cve != "" {
+		return issue.Type + ":" + issue.Cve
+	}
+	return issue.Type + ":" + issue.Summary
+}
+
+func isManifest(file string) bool {
+	name := path.Base(file)
+	for _, pattern := range manifestPatterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the attribution of a new finding to the commit which introduced it.
+func formatIntroducedFinding(commit *TimelineCommit, issue ScanIssue) string {
+	text := "Commit '" + ToShortCommitHash(commit.Commit) + "' by " + commit.Author + " introduced " + formatFinding(issue)
+	if len(commit.Manifests) > 0 {
+		return text + ". Changed manifests: " + strings.Join(commit.Manifests, ", ")
+	}
+	return text + ". No manifest was changed"
+}
+
+// Upload content as a JSON file to an Artifactory path, with properties in the 'key1=value1;key2=value2' format.
+func uploadJson(servicesManager artifactory.ArtifactoryServicesManager, target, props string, content interface{}) error {
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile("", "vcs-agent-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	params := services.NewUploadParams()
+	params.Pattern = file.Name()
+	params.Target = target
+	params.Flat = true
+	params.TargetProps = props
+	return Retry("Uploading '"+target+"'", func() error {
+		uploaded, _, err := servicesManager.UploadFiles(params)
+		if err == nil && uploaded == 0 {
+			err = fmt.Errorf("failed to upload '%s'", target)
+		}
+		return err
+	})
+}
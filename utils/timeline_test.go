@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVulnerabilityTimeline(t *testing.T) {
+	setupRetryTest(t, &RetryPolicy{MaxAttempts: 1})
+	uploads := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/my-results/") {
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			uploads[r.URL.Path] = string(body)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	servicesManager := createTestServicesManager(t, server)
+	jfrog := &JfrogDetails{ArtUrl: server.URL + "/", ScanResultsRepo: "my-results"}
+
+	r, path := createRepoWithFiles(t, map[string]string{"README.md": "readme"})
+	head, err := r.Head()
+	assert.NoError(t, err)
+	first := head.Hash().String()
+	second := commitFile(t, r, path, "pom.xml", "<project/>").String()
+	third := commitFile(t, r, path, "README.md", "updated").String()
+	b, err := NewGitBackend(path, &Vcs{})
+	assert.NoError(t, err)
+
+	oldIssue := ScanIssue{Summary: "Old", Type: "security", Severity: "High", Cve: "CVE-2021-0001"}
+	newIssue := ScanIssue{Summary: "New", Type: "security", Severity: "Critical", Cve: "CVE-2021-0002"}
+	licenseIssue := ScanIssue{Summary: "GPL-3.0", Type: "license", Severity: "Medium"}
+
+	timeline, err := LoadVulnerabilityTimeline(servicesManager, "my-build", "main", jfrog)
+	assert.NoError(t, err)
+	assert.Empty(t, timeline.Findings)
+
+	// The findings of the first scan are the baseline of the branch.
+	diff, err := timeline.Record(servicesManager, b, first, "1", createScanResult(oldIssue, licenseIssue))
+	assert.NoError(t, err)
+	assert.Equal(t, ScanDiff{{oldIssue, NewFinding}, {licenseIssue, NewFinding}}, diff)
+	assert.Contains(t, uploads, "/my-results/my-build/1.json;build.name=my-build;build.number=1;vcs.revision="+first+";")
+	if assert.Len(t, timeline.Findings, 2) {
+		assert.True(t, timeline.Findings[0].Baseline)
+	}
+
+	// The new finding is attributed to the commit which changed the manifest.
+	diff, err = timeline.Record(servicesManager, b, second, "2", createScanResult(oldIssue, newIssue, licenseIssue))
+	assert.NoError(t, err)
+	assert.Equal(t, ScanDiff{{newIssue, NewFinding}, {oldIssue, UnchangedFinding}, {licenseIssue, UnchangedFinding}}, diff)
+	if assert.Len(t, timeline.Findings, 3) {
+		introduced := timeline.Findings[2]
+		assert.Equal(t, newIssue, introduced.ScanIssue)
+		assert.False(t, introduced.Baseline)
+		assert.Equal(t, &TimelineCommit{Commit: second, Author: "test <test@jfrog.com>", Date: introduced.Introduced.Date, BuildNumber: "2", Manifests: []string{"pom.xml"}}, introduced.Introduced)
+		assert.Nil(t, introduced.Fixed)
+	}
+
+	// A missing result doesn't fix the open findings.
+	diff, err = timeline.Record(servicesManager, b, third, "3", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, diff)
+	assert.Nil(t, timeline.Findings[0].Fixed)
+
+	diff, err = timeline.Record(servicesManager, b, third, "3", createScanResult(newIssue))
+	assert.NoError(t, err)
+	assert.Equal(t, ScanDiff{{newIssue, UnchangedFinding}, {oldIssue, FixedFinding}, {licenseIssue, FixedFinding}}, diff)
+	if assert.NotNil(t, timeline.Findings[0].Fixed) {
+		assert.Equal(t, third, timeline.Findings[0].Fixed.Commit)
+		assert.Empty(t, timeline.Findings[0].Fixed.Manifests)
+	}
+	assert.NotNil(t, timeline.Findings[1].Fixed)
+	assert.Nil(t, timeline.Findings[2].Fixed)
+
+	// The stored timeline is loaded by the next run.
+	stored := new(VulnerabilityTimeline)
+	assert.NoError(t, json.Unmarshal([]byte(uploads["/my-results/my-build/"+vulnerabilityTimelineFile+";build.name=my-build;vcs.branch=main;"]), stored))
+	assert.Equal(t, third, stored.LastCommit)
+	assert.Len(t, stored.Findings, 3)
+
+	// The timeline isn't recorded without a repository.
+	timeline, err = LoadVulnerabilityTimeline(servicesManager, "my-build", "main", &JfrogDetails{})
+	assert.NoError(t, err)
+	assert.Nil(t, timeline)
+	diff, err = timeline.Record(servicesManager, b, third, "4", createScanResult(newIssue))
+	assert.NoError(t, err)
+	assert.Nil(t, diff)
+}
+
+func TestUpdateVulnerabilityTimeline(t *testing.T) {
+	issue := ScanIssue{Summary: "Vulnerable", Type: "security", Severity: "High", Cve: "CVE-2021-0001"}
+	timeline := &VulnerabilityTimeline{LastCommit: "a"}
+	assert.Equal(t, ScanDiff{{issue, NewFinding}}, timeline.update(&TimelineCommit{Commit: "b"}, []ScanIssue{issue}))
+
+	// A finding is identified by its CVE, and its details are updated.
+	issue.Severity = "Critical"
+	assert.Equal(t, ScanDiff{{issue, UnchangedFinding}}, timeline.update(&TimelineCommit{Commit: "c"}, []ScanIssue{issue, issue}))
+	assert.Equal(t, "Critical", timeline.Findings[0].Severity)
+
+	assert.Equal(t, ScanDiff{{issue, FixedFinding}}, timeline.update(&TimelineCommit{Commit: "d"}, nil))
+
+	// A finding which is introduced again is a new finding.
+	assert.Equal(t, ScanDiff{{issue, NewFinding}}, timeline.update(&TimelineCommit{Commit: "e"}, []ScanIssue{issue}))
+	assert.Len(t, timeline.Findings, 2)
+	assert.Equal(t, "e", timeline.LastCommit)
+}
+
+func TestIsManifest(t *testing.T) {
+	assert.True(t, isManifest("pom.xml"))
+	assert.True(t, isManifest("frontend/package-lock.json"))
+	assert.True(t, isManifest("requirements-dev.txt"))
+	assert.False(t, isManifest("src/main/java/App.java"))
+}
+
+func createScanResult(issues ...ScanIssue) *ScanResult {
+	return &ScanResult{Alerts: []ScanAlert{{Issues: issues}}}
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// The severities of Xray issues, from the lowest to the highest.
+var severities = []string{"Unknown", "Information", "Low", "Medium", "High", "Critical"}
+
+// The result of a build scan, as printed by JFrog CLI.
+type ScanResult struct {
+	Summary ScanSummary `json:"summary"`
+	Alerts  []ScanAlert `json:"alerts"`
+}
+
+type ScanSummary struct {
+	TotalAlerts    int    `json:"total_alerts"`
+	FailBuild      bool   `json:"fail_build"`
+	Message        string `json:"message"`
+	MoreDetailsUrl string `json:"more_details_url"`
+}
+
+// The violations of an Xray watch.
+type ScanAlert struct {
+	TopSeverity string      `json:"top_severity"`
+	WatchName   string      `json:"watch_name"`
+	Issues      []ScanIssue `json:"issues"`
+}
+
+// A security or license violation.
+type ScanIssue struct {
+	Summary     string `json:"summary"`
+	Description string `json:"description"`
+	// 'security' or 'license'.
+	Type     string `json:"type"`
+	Severity string `json:"severity"`
+	Cve      string `json:"cve"`
+}
+
+// Returns the distinct issues of all the alerts, from the highest to the lowest severity.
+func (r *ScanResult) Issues() []ScanIssue {
+	if r == nil {
+		return nil
+	}
+	var issues []ScanIssue
+	seen := map[ScanIssue]bool{}
+	for _, alert := range r.Alerts {
+		for _, issue := range alert.Issues {
+			if !seen[issue] {
+				seen[issue] = true
+				issues = append(issues, issue)
+			}
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		return severityRank(issues[i].Severity) > severityRank(issues[j].Severity)
+	})
+	return issues
+}
+
+// Returns the rank of a severity in 'severities'. Unknown severities have the lowest rank.
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+	return 0
+}
+
+// Parse the output of 'jfrog rt bs'. Returns nil if the output is empty.
+func parseScanResult(output []byte) (*ScanResult, error) {
+	output = bytes.TrimSpace(output)
+	if len(output) == 0 {
+		return nil, nil
+	}
+	start := bytes.IndexByte(output, '{')
+	if start < 0 {
+		return nil, errors.New("the build scan output doesn't contain a JSON result")
+	}
+	result := new(ScanResult)
+	if err := json.Unmarshal(output[start:], result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// The status of a finding, compared to the findings of the previous scanned commit of the branch.
+type FindingStatus string
+
+const (
+	NewFinding       FindingStatus = "new"
+	FixedFinding     FindingStatus = "fixed"
+	UnchangedFinding FindingStatus = "unchanged"
+)
+
+type ScanFinding struct {
+	ScanIssue
+	Status FindingStatus `json:"status,omitempty"`
+}
+
+// The findings of a commit compared to the previous scanned commit of the branch: the new and unchanged findings, from the highest
+// to the lowest severity, followed by the fixed findings.
+type ScanDiff []ScanFinding
+
+// Returns the findings with one of the given statuses.
+func (d ScanDiff) Filter(statuses ...FindingStatus) ScanDiff {
+	var filtered ScanDiff
+	for _, finding := range d {
+		for _, status := range statuses {
+			if finding.Status == status {
+				filtered = append(filtered, finding)
+				break
+			}
+		}
+	}
+	return filtered
+}